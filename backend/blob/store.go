@@ -0,0 +1,57 @@
+// Package blob stores binary objects such as item images outside the database.
+// Objects are content-addressed: the key of an object is the hex encoded
+// SHA-256 of its data, so storing the same image twice is a no-op. Objects are
+// never deleted: the same key can be shared by several items, and item
+// revisions keep referring to replaced images so that they can be reverted.
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+type Store interface {
+	// Put stores data and returns its key.
+	Put(ctx context.Context, data []byte) (string, error)
+	// Get returns the data stored under key, or ErrNotFound.
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// Key returns the content address of data.
+func Key(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// NewStoreFromEnv builds the store selected by BLOB_STORE ("local" by default or "s3").
+func NewStoreFromEnv() (Store, error) {
+	switch kind := getEnv("BLOB_STORE", "local"); kind {
+	case "local":
+		return NewLocalStore(getEnv("BLOB_DIR", "blobs"))
+	case "s3":
+		return NewS3Store(S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			Region:          getEnv("S3_REGION", "us-east-1"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown BLOB_STORE %q", kind)
+	}
+}
+
+func getEnv(key string, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,46 @@
+// Command backfill-placeholders computes the placeholder colour of item images
+// uploaded before placeholders existed. Run it from the backend directory,
+// like the server, after migrate-blobs if the database still holds images inline.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/blob"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/db"
+)
+
+const (
+	exitOK = iota
+	exitError
+)
+
+func main() {
+	os.Exit(run(context.Background()))
+}
+
+func run(ctx context.Context) int {
+	sqlDB, err := db.PrepareDB(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to prepare DB: %s\n", err)
+		return exitError
+	}
+	defer sqlDB.Close()
+
+	store, err := blob.NewStoreFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to prepare blob store: %s\n", err)
+		return exitError
+	}
+
+	added, err := db.BackfillPlaceholders(ctx, sqlDB, store)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed after adding %d placeholders: %s\n", added, err)
+		return exitError
+	}
+
+	fmt.Printf("added %d placeholders\n", added)
+	return exitOK
+}
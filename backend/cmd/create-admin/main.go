@@ -0,0 +1,82 @@
+// Command create-admin bootstraps the first admin. It either registers a new
+// admin with -name and -password, or promotes an existing user with -user-id.
+// Run it from the backend directory, like the server. Since POST /initialize
+// recreates the users table, run it again after initializing.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/db"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/password"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	exitOK = iota
+	exitError
+	exitUsage
+)
+
+func main() {
+	os.Exit(run(context.Background()))
+}
+
+func run(ctx context.Context) int {
+	name := flag.String("name", "", "name of the admin to register")
+	pass := flag.String("password", "", "password of the admin to register")
+	userID := flag.Int64("user-id", 0, "ID of an existing user to promote to admin")
+	flag.Parse()
+
+	if (*userID == 0) == (*name == "" || *pass == "") {
+		fmt.Fprintln(os.Stderr, "usage: create-admin -name NAME -password PASSWORD | -user-id ID")
+		return exitUsage
+	}
+
+	sqlDB, err := db.PrepareDB(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to prepare DB: %s\n", err)
+		return exitError
+	}
+	defer sqlDB.Close()
+
+	userRepo := db.NewUserRepository(sqlDB)
+
+	if *userID != 0 {
+		if err := userRepo.SetUserRole(ctx, *userID, domain.RoleAdmin); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to promote user %d: %s\n", *userID, err)
+			return exitError
+		}
+		fmt.Printf("promoted user %d to admin\n", *userID)
+		return exitOK
+	}
+
+	policy, err := password.PolicyFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load password policy: %s\n", err)
+		return exitError
+	}
+	if err := policy.Check(*pass, *name); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid password: %s\n", err)
+		return exitUsage
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(*pass), bcrypt.DefaultCost)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to hash password: %s\n", err)
+		return exitError
+	}
+
+	id, err := userRepo.AddUser(ctx, domain.User{Name: *name, Password: string(hash), Role: domain.RoleAdmin})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to add admin: %s\n", err)
+		return exitError
+	}
+
+	fmt.Printf("added admin %d\n", id)
+	return exitOK
+}
@@ -0,0 +1,51 @@
+// Command migrate-blobs moves item images stored inside the database into the
+// blob store configured by BLOB_STORE, leaving only their keys in the items
+// table. Images are processed like uploads, so their metadata is stripped and
+// their variants, fingerprints and placeholders are stored along with them.
+// Run it from the backend directory, like the server.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/blob"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/db"
+)
+
+const (
+	exitOK = iota
+	exitError
+)
+
+func main() {
+	os.Exit(run(context.Background()))
+}
+
+func run(ctx context.Context) int {
+	sqlDB, err := db.PrepareDB(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to prepare DB: %s\n", err)
+		return exitError
+	}
+	defer sqlDB.Close()
+
+	store, err := blob.NewStoreFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to prepare blob store: %s\n", err)
+		return exitError
+	}
+
+	moved, skipped, err := db.MigrateImagesToBlobStore(ctx, sqlDB, store)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed after moving %d images: %s\n", moved, err)
+		return exitError
+	}
+
+	fmt.Printf("moved %d images to the blob store\n", moved)
+	if len(skipped) > 0 {
+		fmt.Fprintf(os.Stderr, "left %d images that fail to decode inline, of items %v\n", len(skipped), skipped)
+	}
+	return exitOK
+}
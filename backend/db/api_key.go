@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+type APIKeyRepository interface {
+	AddAPIKey(ctx context.Context, key domain.APIKey, hash string, ttl time.Duration) (domain.APIKey, error)
+	GetAPIKeys(ctx context.Context, userID int64) ([]domain.APIKey, error)
+	UseAPIKey(ctx context.Context, hash string) (domain.APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID int64, id int64) error
+	RevokeUserAPIKeys(ctx context.Context, userID int64) error
+}
+
+type APIKeyDBRepository struct {
+	*sql.DB
+}
+
+func NewAPIKeyRepository(db *sql.DB) APIKeyRepository {
+	return &APIKeyDBRepository{DB: db}
+}
+
+// AddAPIKey stores a key by its hash and returns it as stored.
+func (r *APIKeyDBRepository) AddAPIKey(ctx context.Context, key domain.APIKey, hash string, ttl time.Duration) (domain.APIKey, error) {
+	res, err := r.ExecContext(ctx, "INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at) VALUES (?, ?, ?, ?, ?, DATETIME('now', 'localtime', ?))", key.UserID, key.Name, key.Prefix, hash, joinScopes(key.Scopes), ttlModifier(ttl))
+	if err != nil {
+		return domain.APIKey{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return domain.APIKey{}, err
+	}
+
+	row := r.QueryRowContext(ctx, "SELECT id, user_id, name, prefix, scopes, expires_at, IFNULL(last_used_at, ''), created_at FROM api_keys WHERE id = ?", id)
+	return scanAPIKey(row)
+}
+
+// GetAPIKeys returns the keys of a user that were not revoked, newest first.
+// Expired keys are included so their owner sees why a script stopped working.
+func (r *APIKeyDBRepository) GetAPIKeys(ctx context.Context, userID int64) ([]domain.APIKey, error) {
+	rows, err := r.QueryContext(ctx, "SELECT id, user_id, name, prefix, scopes, expires_at, IFNULL(last_used_at, ''), created_at FROM api_keys WHERE user_id = ? AND revoked_at IS NULL ORDER BY id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []domain.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// UseAPIKey looks up an active key by the hash of its value and records that
+// it was used, returning sql.ErrNoRows for unknown, revoked and expired keys.
+// last_used_at is written at most once a minute like for sessions.
+func (r *APIKeyDBRepository) UseAPIKey(ctx context.Context, hash string) (domain.APIKey, error) {
+	row := r.QueryRowContext(ctx, "SELECT id, user_id, name, prefix, scopes, expires_at, IFNULL(last_used_at, ''), created_at FROM api_keys WHERE key_hash = ? AND revoked_at IS NULL AND expires_at > DATETIME('now', 'localtime')", hash)
+	key, err := scanAPIKey(row)
+	if err != nil {
+		return domain.APIKey{}, err
+	}
+
+	_, err = r.ExecContext(ctx, "UPDATE api_keys SET last_used_at = DATETIME('now', 'localtime') WHERE id = ? AND (last_used_at IS NULL OR last_used_at < DATETIME('now', 'localtime', '-1 minutes'))", key.ID)
+	return key, err
+}
+
+// RevokeAPIKey revokes a key of a user, returning sql.ErrNoRows if the user
+// has no such key.
+func (r *APIKeyDBRepository) RevokeAPIKey(ctx context.Context, userID int64, id int64) error {
+	res, err := r.ExecContext(ctx, "UPDATE api_keys SET revoked_at = DATETIME('now', 'localtime') WHERE id = ? AND user_id = ? AND revoked_at IS NULL", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RevokeUserAPIKeys revokes every key of a user, e.g. when the account is
+// recovered after a password reset.
+func (r *APIKeyDBRepository) RevokeUserAPIKeys(ctx context.Context, userID int64) error {
+	_, err := r.ExecContext(ctx, "UPDATE api_keys SET revoked_at = DATETIME('now', 'localtime') WHERE user_id = ? AND revoked_at IS NULL", userID)
+	return err
+}
+
+func scanAPIKey(row rowScanner) (domain.APIKey, error) {
+	var key domain.APIKey
+	var scopes string
+	if err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &scopes, &key.ExpiresAt, &key.LastUsedAt, &key.CreatedAt); err != nil {
+		return domain.APIKey{}, err
+	}
+	for _, s := range strings.Fields(scopes) {
+		key.Scopes = append(key.Scopes, domain.APIKeyScope(s))
+	}
+	return key, nil
+}
+
+func joinScopes(scopes []domain.APIKeyScope) string {
+	s := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		s = append(s, string(scope))
+	}
+	return strings.Join(s, " ")
+}
@@ -0,0 +1,180 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+type AttributeRepository interface {
+	SetCategoryAttribute(ctx context.Context, attr domain.CategoryAttribute) (domain.CategoryAttribute, error)
+	DeleteCategoryAttribute(ctx context.Context, categoryID int64, name string) error
+	GetAttributeSchema(ctx context.Context, categoryID int64) ([]domain.CategoryAttribute, error)
+	GetItemAttributes(ctx context.Context, itemID int32) (map[string]string, error)
+	SetItemAttributes(ctx context.Context, itemID int32, attrs map[string]string) error
+	GetItemIDsByAttributes(ctx context.Context, filters map[string]string) (map[int32]bool, error)
+}
+
+type AttributeDBRepository struct {
+	*sql.DB
+}
+
+func NewAttributeRepository(db *sql.DB) AttributeRepository {
+	return &AttributeDBRepository{DB: db}
+}
+
+// SetCategoryAttribute adds an attribute to a category, replacing the
+// definition of an attribute with the same name.
+func (r *AttributeDBRepository) SetCategoryAttribute(ctx context.Context, attr domain.CategoryAttribute) (domain.CategoryAttribute, error) {
+	allowed, err := json.Marshal(attr.AllowedValues)
+	if err != nil {
+		return domain.CategoryAttribute{}, err
+	}
+
+	row := r.QueryRowContext(ctx, `INSERT INTO category_attributes (category_id, name, type, allowed_values, required) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (category_id, name) DO UPDATE SET type = excluded.type, allowed_values = excluded.allowed_values, required = excluded.required
+		RETURNING id`, attr.CategoryID, attr.Name, attr.Type, string(allowed), attr.Required)
+	if err := row.Scan(&attr.ID); err != nil {
+		return domain.CategoryAttribute{}, err
+	}
+	return attr, nil
+}
+
+func (r *AttributeDBRepository) DeleteCategoryAttribute(ctx context.Context, categoryID int64, name string) error {
+	result, err := r.ExecContext(ctx, "DELETE FROM category_attributes WHERE category_id = ? AND name = ?", categoryID, name)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetAttributeSchema returns the attributes items of a category can carry:
+// its own and the ones inherited from its ancestors. A category redefining
+// an inherited attribute overrides it.
+func (r *AttributeDBRepository) GetAttributeSchema(ctx context.Context, categoryID int64) ([]domain.CategoryAttribute, error) {
+	return attributeSchema(ctx, r.DB, categoryID)
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func attributeSchema(ctx context.Context, q querier, categoryID int64) ([]domain.CategoryAttribute, error) {
+	rows, err := q.QueryContext(ctx, `WITH RECURSIVE path(id, parent_id, depth) AS (
+			SELECT id, parent_id, 0 FROM category WHERE id = ?
+			UNION ALL SELECT category.id, category.parent_id, path.depth + 1 FROM category JOIN path ON category.id = path.parent_id
+		)
+		SELECT category_attributes.id, category_attributes.category_id, category_attributes.name, category_attributes.type, category_attributes.allowed_values, category_attributes.required
+		FROM category_attributes JOIN path ON path.id = category_attributes.category_id
+		ORDER BY path.depth, category_attributes.id`, categoryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := make(map[string]bool)
+	var schema []domain.CategoryAttribute
+	for rows.Next() {
+		var attr domain.CategoryAttribute
+		var allowed string
+		if err := rows.Scan(&attr.ID, &attr.CategoryID, &attr.Name, &attr.Type, &allowed, &attr.Required); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(allowed), &attr.AllowedValues); err != nil {
+			return nil, err
+		}
+		// Rows come nearest category first
+		if seen[attr.Name] {
+			continue
+		}
+		seen[attr.Name] = true
+		schema = append(schema, attr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+func (r *AttributeDBRepository) GetItemAttributes(ctx context.Context, itemID int32) (map[string]string, error) {
+	rows, err := r.QueryContext(ctx, "SELECT name, value FROM item_attributes WHERE item_id = ?", itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attrs := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		attrs[name] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return attrs, nil
+}
+
+// SetItemAttributes replaces all attributes of an item.
+func (r *AttributeDBRepository) SetItemAttributes(ctx context.Context, itemID int32, attrs map[string]string) error {
+	tx, err := r.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM item_attributes WHERE item_id = ?", itemID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for name, value := range attrs {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO item_attributes (item_id, name, value) VALUES (?, ?, ?)", itemID, name, value); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetItemIDsByAttributes returns the IDs of the items carrying every one of
+// the given attribute values. filters must not be empty.
+func (r *AttributeDBRepository) GetItemIDsByAttributes(ctx context.Context, filters map[string]string) (map[int32]bool, error) {
+	conditions := make([]string, 0, len(filters))
+	args := make([]interface{}, 0, 2*len(filters)+1)
+	for name, value := range filters {
+		conditions = append(conditions, "(name = ? AND value = ?)")
+		args = append(args, name, value)
+	}
+	args = append(args, len(filters))
+
+	rows, err := r.QueryContext(ctx, "SELECT item_id FROM item_attributes WHERE "+strings.Join(conditions, " OR ")+" GROUP BY item_id HAVING COUNT(*) = ?", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[int32]bool)
+	for rows.Next() {
+		var id int32
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/blob"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/imageproc"
+)
+
+// MigrateImagesToBlobStore moves images still stored inside the items table
+// into store. Each one is processed like an upload: only its re-encoded,
+// metadata-free variants are stored, they are fingerprinted and given a
+// placeholder, and items.image_hash is set to the full size variant.
+// Databases created before the column existed get it added first. Images
+// that fail to decode stay inline, and their items are returned in skipped.
+// It returns the number of images moved.
+func MigrateImagesToBlobStore(ctx context.Context, db *sql.DB, store blob.Store) (int, []int32, error) {
+	if err := addColumnIfMissing(ctx, db, "items", "image_hash", "varchar(64)"); err != nil {
+		return 0, nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT id FROM items WHERE image IS NOT NULL AND (image_hash IS NULL OR image_hash = '')")
+	if err != nil {
+		return 0, nil, err
+	}
+	var ids []int32
+	for rows.Next() {
+		var id int32
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	// Move images one at a time so large databases are never loaded into memory at once
+	repo := &ItemDBRepository{DB: db, Blobs: store}
+	duplicates := &DuplicateDBRepository{DB: db}
+	moved := 0
+	var skipped []int32
+	for _, id := range ids {
+		var image []byte
+		if err := db.QueryRowContext(ctx, "SELECT image FROM items WHERE id = ?", id).Scan(&image); err != nil {
+			return moved, skipped, err
+		}
+
+		variants, err := imageproc.Process(image)
+		var invalid *imageproc.ValidationError
+		if errors.As(err, &invalid) {
+			skipped = append(skipped, id)
+			continue
+		}
+		if err != nil {
+			return moved, skipped, err
+		}
+
+		hashes := make(map[domain.ImageSize]string, len(variants))
+		for size, variant := range variants {
+			if hashes[size], err = store.Put(ctx, variant); err != nil {
+				return moved, skipped, err
+			}
+		}
+		full := hashes[domain.ImageSizeFull]
+		if err := repo.AddImageVariants(ctx, full, hashes); err != nil {
+			return moved, skipped, err
+		}
+		// Like uploads, the thumbnail gives the fingerprint and the colour
+		if phash, err := imageproc.DHash(variants[domain.ImageSizeThumb]); err == nil {
+			if err := duplicates.AddImageFingerprint(ctx, full, phash); err != nil {
+				return moved, skipped, err
+			}
+		}
+		if color, err := imageproc.DominantColor(variants[domain.ImageSizeThumb]); err == nil {
+			if err := repo.AddImagePlaceholder(ctx, full, color); err != nil {
+				return moved, skipped, err
+			}
+		}
+
+		if _, err := db.ExecContext(ctx, "UPDATE items SET image_hash = ?, image = NULL WHERE id = ?", full, id); err != nil {
+			return moved, skipped, err
+		}
+		moved++
+	}
+
+	return moved, skipped, nil
+}
+
+func addColumnIfMissing(ctx context.Context, db *sql.DB, table string, column string, definition string) error {
+	row := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column)
+
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	_, err := db.ExecContext(ctx, "ALTER TABLE "+table+" ADD COLUMN "+column+" "+definition)
+	return err
+}
@@ -0,0 +1,375 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+var (
+	// ErrCategoryCycle is returned when a category would become its own ancestor.
+	ErrCategoryCycle = errors.New("category cannot be moved below itself")
+	// ErrCategoryNotEmpty is returned when deleting a category still holding items or subcategories.
+	ErrCategoryNotEmpty = errors.New("category still has items or subcategories")
+	// ErrCategoryExists is returned when a merge would leave two subcategories with the same name.
+	ErrCategoryExists = errors.New("target category already has a subcategory with this name")
+)
+
+const categoryColumns = "id, name, parent_id, IFNULL(updated_at, '')"
+
+func scanCategory(row rowScanner) (domain.Category, error) {
+	var cat domain.Category
+	var parentID sql.NullInt64
+	err := row.Scan(&cat.ID, &cat.Name, &parentID, &cat.UpdatedAt)
+	cat.ParentID = parentID.Int64
+	return cat, err
+}
+
+func scanCategories(rows *sql.Rows) ([]domain.Category, error) {
+	defer rows.Close()
+
+	var cats []domain.Category
+	for rows.Next() {
+		cat, err := scanCategory(rows)
+		if err != nil {
+			return nil, err
+		}
+		cats = append(cats, cat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return cats, nil
+}
+
+// GetItemsInCategoryTree returns the items of a category and of all its descendants.
+func (r *ItemDBRepository) GetItemsInCategoryTree(ctx context.Context, categoryID int64) ([]domain.Item, error) {
+	rows, err := r.QueryContext(ctx, `WITH RECURSIVE tree(id) AS (
+			SELECT ?
+			UNION SELECT category.id FROM category JOIN tree ON category.parent_id = tree.id
+		)
+		SELECT `+itemColumns+` FROM items WHERE category_id IN (SELECT id FROM tree)`, categoryID)
+	if err != nil {
+		return nil, err
+	}
+	return scanItems(rows)
+}
+
+// GetCategoryPath returns the ancestors of a category from the top level
+// down, ending with the category itself.
+func (r *ItemDBRepository) GetCategoryPath(ctx context.Context, id int64) ([]domain.Category, error) {
+	rows, err := r.QueryContext(ctx, `WITH RECURSIVE path(id, name, parent_id, updated_at, depth) AS (
+			SELECT id, name, parent_id, updated_at, 0 FROM category WHERE id = ?
+			UNION ALL SELECT category.id, category.name, category.parent_id, category.updated_at, path.depth + 1 FROM category JOIN path ON category.id = path.parent_id
+		)
+		SELECT `+categoryColumns+` FROM path ORDER BY depth DESC`, id)
+	if err != nil {
+		return nil, err
+	}
+	return scanCategories(rows)
+}
+
+// SetCategoryParent moves a category below parentID, or to the top level when
+// parentID is 0. Moving a category below one of its descendants fails with
+// ErrCategoryCycle.
+func (r *ItemDBRepository) SetCategoryParent(ctx context.Context, id int64, parentID int64) error {
+	tx, err := r.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return err
+	}
+
+	if parentID != 0 {
+		row := tx.QueryRowContext(ctx, `WITH RECURSIVE tree(id) AS (
+				SELECT ?
+				UNION SELECT category.id FROM category JOIN tree ON category.parent_id = tree.id
+			)
+			SELECT COUNT(*) FROM tree WHERE id = ?`, id, parentID)
+		var descendant int
+		if err := row.Scan(&descendant); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if descendant > 0 {
+			tx.Rollback()
+			return ErrCategoryCycle
+		}
+	}
+
+	parent := sql.NullInt64{Int64: parentID, Valid: parentID != 0}
+	result, err := tx.ExecContext(ctx, "UPDATE category SET parent_id = ?, updated_at = DATETIME('now', 'localtime') WHERE id = ?", parent, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n == 0 {
+		tx.Rollback()
+		return sql.ErrNoRows
+	}
+
+	return tx.Commit()
+}
+
+// GetCategoryByName finds the category below parentID, 0 being the top
+// level, whose name matches name ignoring case and whitespace.
+func (r *ItemDBRepository) GetCategoryByName(ctx context.Context, parentID int64, name string) (domain.Category, error) {
+	rows, err := r.QueryContext(ctx, "SELECT "+categoryColumns+" FROM category WHERE IFNULL(parent_id, 0) = ?", parentID)
+	if err != nil {
+		return domain.Category{}, err
+	}
+	cats, err := scanCategories(rows)
+	if err != nil {
+		return domain.Category{}, err
+	}
+
+	for _, cat := range cats {
+		if domain.SameCategoryName(cat.Name, name) {
+			return cat, nil
+		}
+	}
+	return domain.Category{}, sql.ErrNoRows
+}
+
+func (r *ItemDBRepository) RenameCategory(ctx context.Context, id int64, name string) error {
+	result, err := r.ExecContext(ctx, "UPDATE category SET name = ?, updated_at = DATETIME('now', 'localtime') WHERE id = ?", name, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// MergeCategory moves the items and subcategories of a category into
+// another one and deletes it, returning the number of items moved and the
+// number of item attributes dropped. Merging a category into one of its
+// descendants fails with ErrCategoryCycle, and merging subcategories into a
+// category already holding one of the same name with ErrCategoryExists.
+//
+// Moved items lose the attributes of the merged category and inherit the
+// ones of the target, so attribute values the new schema doesn't define or
+// accept are dropped.
+func (r *ItemDBRepository) MergeCategory(ctx context.Context, id int64, intoID int64) (int64, int64, error) {
+	tx, err := r.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	row := tx.QueryRowContext(ctx, `WITH RECURSIVE tree(id) AS (
+			SELECT ?
+			UNION SELECT category.id FROM category JOIN tree ON category.parent_id = tree.id
+		)
+		SELECT COUNT(*) FROM tree WHERE id = ?`, id, intoID)
+	var descendant int
+	if err := row.Scan(&descendant); err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+	if descendant > 0 {
+		tx.Rollback()
+		return 0, 0, ErrCategoryCycle
+	}
+
+	if err := checkSubcategoryNames(ctx, tx, id, intoID); err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+	attrs, err := categoryTreeAttributes(ctx, tx, id)
+	if err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, "UPDATE items SET category_id = ?, updated_at = DATETIME('now', 'localtime') WHERE category_id = ?", intoID, id)
+	if err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+	moved, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE category SET parent_id = ?, updated_at = DATETIME('now', 'localtime') WHERE parent_id = ?", intoID, id); err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+	if err := deleteCategory(ctx, tx, id); err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+
+	for i := range attrs {
+		if attrs[i].categoryID == id {
+			attrs[i].categoryID = intoID
+		}
+	}
+	dropped, err := revalidateItemAttributes(ctx, tx, attrs)
+	if err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+
+	return moved, dropped, tx.Commit()
+}
+
+// checkSubcategoryNames fails with ErrCategoryExists if a subcategory of id
+// has the same name as one of intoID.
+func checkSubcategoryNames(ctx context.Context, tx *sql.Tx, id int64, intoID int64) error {
+	rows, err := tx.QueryContext(ctx, "SELECT "+categoryColumns+" FROM category WHERE parent_id = ?", id)
+	if err != nil {
+		return err
+	}
+	moving, err := scanCategories(rows)
+	if err != nil {
+		return err
+	}
+	rows, err = tx.QueryContext(ctx, "SELECT "+categoryColumns+" FROM category WHERE parent_id = ?", intoID)
+	if err != nil {
+		return err
+	}
+	existing, err := scanCategories(rows)
+	if err != nil {
+		return err
+	}
+
+	for _, cat := range moving {
+		for _, other := range existing {
+			if domain.SameCategoryName(cat.Name, other.Name) {
+				return errors.Wrapf(ErrCategoryExists, "%q", other.Name)
+			}
+		}
+	}
+	return nil
+}
+
+type itemAttribute struct {
+	itemID     int32
+	categoryID int64
+	name       string
+	value      string
+}
+
+// categoryTreeAttributes returns the attribute values of the items of a
+// category and of all its descendants.
+func categoryTreeAttributes(ctx context.Context, tx *sql.Tx, categoryID int64) ([]itemAttribute, error) {
+	rows, err := tx.QueryContext(ctx, `WITH RECURSIVE tree(id) AS (
+			SELECT ?
+			UNION SELECT category.id FROM category JOIN tree ON category.parent_id = tree.id
+		)
+		SELECT items.id, items.category_id, item_attributes.name, item_attributes.value
+		FROM item_attributes JOIN items ON items.id = item_attributes.item_id
+		WHERE items.category_id IN (SELECT id FROM tree)`, categoryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attrs []itemAttribute
+	for rows.Next() {
+		var attr itemAttribute
+		if err := rows.Scan(&attr.itemID, &attr.categoryID, &attr.name, &attr.value); err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, attr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return attrs, nil
+}
+
+// revalidateItemAttributes checks attribute values against the current
+// schema of their item's category, deleting the ones it doesn't define or
+// accept and normalizing the rest. It returns the number deleted.
+func revalidateItemAttributes(ctx context.Context, tx *sql.Tx, attrs []itemAttribute) (int64, error) {
+	schemas := make(map[int64]map[string]domain.CategoryAttribute)
+	var dropped int64
+	for _, attr := range attrs {
+		schema, ok := schemas[attr.categoryID]
+		if !ok {
+			list, err := attributeSchema(ctx, tx, attr.categoryID)
+			if err != nil {
+				return 0, err
+			}
+			schema = make(map[string]domain.CategoryAttribute, len(list))
+			for _, def := range list {
+				schema[def.Name] = def
+			}
+			schemas[attr.categoryID] = schema
+		}
+
+		def, ok := schema[attr.name]
+		normalized, err := domain.NormalizeAttributeValue(def.Type, attr.value)
+		if !ok || err != nil || !def.Allows(normalized) {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM item_attributes WHERE item_id = ? AND name = ?", attr.itemID, attr.name); err != nil {
+				return 0, err
+			}
+			dropped++
+			continue
+		}
+		if normalized != attr.value {
+			if _, err := tx.ExecContext(ctx, "UPDATE item_attributes SET value = ? WHERE item_id = ? AND name = ?", normalized, attr.itemID, attr.name); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return dropped, nil
+}
+
+// DeleteCategory deletes a category without items or subcategories, failing
+// with ErrCategoryNotEmpty otherwise.
+func (r *ItemDBRepository) DeleteCategory(ctx context.Context, id int64) error {
+	tx, err := r.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return err
+	}
+
+	row := tx.QueryRowContext(ctx, "SELECT (SELECT COUNT(*) FROM items WHERE category_id = ?) + (SELECT COUNT(*) FROM category WHERE parent_id = ?)", id, id)
+	var children int
+	if err := row.Scan(&children); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if children > 0 {
+		tx.Rollback()
+		return ErrCategoryNotEmpty
+	}
+
+	if err := deleteCategory(ctx, tx, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteCategory deletes a category along with its attribute definitions.
+func deleteCategory(ctx context.Context, tx *sql.Tx, id int64) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM category_attributes WHERE category_id = ?", id); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM category WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
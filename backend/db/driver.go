@@ -30,9 +30,69 @@ func PrepareDB(ctx context.Context) (*sql.DB, error) {
 		return nil, errors.Wrap(err, "failed to open schema.sql %w")
 	}
 
+	// The schema adds a unique index on names that older databases may break
+	if err = renameDuplicateUserNames(ctx, db); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate schema: %w")
+	}
 	if _, err = db.ExecContext(ctx, string(f)); err != nil {
 		return nil, errors.Wrap(err, "failed to exec query: %w")
 	}
 
+	// Tables created by older schemas miss the columns added since
+	// Needed by every item query, even before cmd/migrate-blobs has moved the inline images out
+	if err = addColumnIfMissing(ctx, db, "items", "image_hash", "varchar(64)"); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate schema: %w")
+	}
+	if err = addColumnIfMissing(ctx, db, "category", "parent_id", "integer references category(id)"); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate schema: %w")
+	}
+	if err = addColumnIfMissing(ctx, db, "users", "role", "varchar(10) NOT NULL DEFAULT 'user'"); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate schema: %w")
+	}
+	if err = addColumnIfMissing(ctx, db, "users", "email", "varchar(254)"); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate schema: %w")
+	}
+	for _, column := range [][2]string{
+		{"display_name", "varchar(50)"},
+		{"bio", "text"},
+		{"location", "varchar(100)"},
+		{"avatar_hash", "varchar(64)"},
+		// Set on insert, as SQLite can't add a column defaulting to the time
+		{"created_at", "text"},
+	} {
+		if err = addColumnIfMissing(ctx, db, "users", column[0], column[1]); err != nil {
+			return nil, errors.Wrap(err, "failed to migrate schema: %w")
+		}
+	}
+	for _, column := range [][2]string{
+		{"ip", "varchar(45)"},
+		{"created_at", "text"},
+	} {
+		if err = addColumnIfMissing(ctx, db, "password_resets", column[0], column[1]); err != nil {
+			return nil, errors.Wrap(err, "failed to migrate schema: %w")
+		}
+	}
+	if err = addColumnIfMissing(ctx, db, "totp_secrets", "failures", "integer NOT NULL DEFAULT 0"); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate schema: %w")
+	}
+	if err = addColumnIfMissing(ctx, db, "totp_secrets", "last_failed_at", "text"); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate schema: %w")
+	}
+	if err = migrateRefreshTokens(ctx, db); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate schema: %w")
+	}
+	if err = migrateFingerprintBands(ctx, db); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate schema: %w")
+	}
+	if err = addColumnIfMissing(ctx, db, "items", "listed_at", "text"); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate schema: %w")
+	}
+	if err = addColumnIfMissing(ctx, db, "category", "updated_at", "text"); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate schema: %w")
+	}
+	if err = backfillListedAt(ctx, db); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate schema: %w")
+	}
+
 	return db, nil
 }
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/imageproc"
+)
+
+type DuplicateRepository interface {
+	AddImageFingerprint(ctx context.Context, hash string, phash uint64) error
+	GetSimilarItems(ctx context.Context, hash string, maxDistance int) ([]domain.SimilarItem, error)
+	AddDuplicateFlags(ctx context.Context, itemID int32, similar []domain.SimilarItem) error
+	GetDuplicateFlags(ctx context.Context) ([]domain.DuplicateFlag, error)
+}
+
+type DuplicateDBRepository struct {
+	*sql.DB
+}
+
+func NewDuplicateRepository(db *sql.DB) DuplicateRepository {
+	return &DuplicateDBRepository{DB: db}
+}
+
+// Fingerprints are indexed by their four 16 bit bands. Two hashes at most d
+// bits apart have a band at most d/4 bits apart, so searching every band for
+// the values that close finds all matches. Beyond maxBandRadius there are too
+// many such values and the fingerprints are scanned instead.
+const (
+	fingerprintBands = 4
+	bandBits         = 16
+	maxBandRadius    = 2
+)
+
+// AddImageFingerprint stores the perceptual hash of the image stored under hash.
+// SQLite integers are signed, so the hash is stored as its int64 bit pattern.
+func (r *DuplicateDBRepository) AddImageFingerprint(ctx context.Context, hash string, phash uint64) error {
+	bands := splitBands(phash)
+	_, err := r.ExecContext(ctx, "INSERT OR REPLACE INTO image_fingerprints (image_hash, phash, band0, band1, band2, band3) VALUES (?, ?, ?, ?, ?, ?)",
+		hash, int64(phash), bands[0], bands[1], bands[2], bands[3])
+	return err
+}
+
+// GetSimilarItems returns the items whose primary image is at most maxDistance
+// bits away from the image stored under hash. Images without a fingerprint,
+// such as the ones uploaded before fingerprinting, never match.
+func (r *DuplicateDBRepository) GetSimilarItems(ctx context.Context, hash string, maxDistance int) ([]domain.SimilarItem, error) {
+	var phash int64
+	if err := r.QueryRowContext(ctx, "SELECT phash FROM image_fingerprints WHERE image_hash = ?", hash).Scan(&phash); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	query := "SELECT items.id, items.seller_id, items.status, image_fingerprints.phash FROM items JOIN image_fingerprints ON image_fingerprints.image_hash = items.image_hash"
+	if radius := maxDistance / fingerprintBands; radius <= maxBandRadius {
+		// The values are generated here, so they are inlined rather than bound
+		// to stay clear of the limit on query parameters.
+		var conditions []string
+		for i, band := range splitBands(uint64(phash)) {
+			var values []string
+			for _, v := range nearbyValues(band, radius, 0) {
+				values = append(values, strconv.FormatInt(v, 10))
+			}
+			conditions = append(conditions, fmt.Sprintf("image_fingerprints.band%d IN (%s)", i, strings.Join(values, ",")))
+		}
+		query += " WHERE " + strings.Join(conditions, " OR ")
+	}
+
+	rows, err := r.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var similar []domain.SimilarItem
+	for rows.Next() {
+		var item domain.SimilarItem
+		var other int64
+		if err := rows.Scan(&item.ItemID, &item.SellerID, &item.Status, &other); err != nil {
+			return nil, err
+		}
+		item.Distance = imageproc.Distance(uint64(phash), uint64(other))
+		if item.Distance <= maxDistance {
+			similar = append(similar, item)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return similar, nil
+}
+
+func splitBands(phash uint64) [fingerprintBands]int64 {
+	var bands [fingerprintBands]int64
+	for i := range bands {
+		bands[i] = int64(phash >> (i * bandBits) & (1<<bandBits - 1))
+	}
+	return bands
+}
+
+// nearbyValues returns every band value at most radius bits away from value,
+// flipping only bits from the bit from on so each value is produced once.
+func nearbyValues(value int64, radius int, from int) []int64 {
+	values := []int64{value}
+	if radius == 0 {
+		return values
+	}
+	for bit := from; bit < bandBits; bit++ {
+		values = append(values, nearbyValues(value^(1<<bit), radius-1, bit+1)...)
+	}
+	return values
+}
+
+// migrateFingerprintBands adds the band columns to fingerprints stored before
+// they were indexed, and the indexes themselves.
+func migrateFingerprintBands(ctx context.Context, db *sql.DB) error {
+	for i := 0; i < fingerprintBands; i++ {
+		if err := addColumnIfMissing(ctx, db, "image_fingerprints", fmt.Sprintf("band%d", i), "integer"); err != nil {
+			return err
+		}
+	}
+	if _, err := db.ExecContext(ctx, "UPDATE image_fingerprints SET band0 = phash & 65535, band1 = (phash >> 16) & 65535, band2 = (phash >> 32) & 65535, band3 = (phash >> 48) & 65535 WHERE band0 IS NULL"); err != nil {
+		return err
+	}
+	for i := 0; i < fingerprintBands; i++ {
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("CREATE INDEX IF NOT EXISTS image_fingerprints_band%d ON image_fingerprints (band%d)", i, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddDuplicateFlags flags itemID as a duplicate of the similar items it
+// isn't flagged for yet.
+func (r *DuplicateDBRepository) AddDuplicateFlags(ctx context.Context, itemID int32, similar []domain.SimilarItem) error {
+	tx, err := r.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return err
+	}
+
+	for _, s := range similar {
+		if s.ItemID == itemID {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO duplicate_flags (item_id, duplicate_of, distance) SELECT ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM duplicate_flags WHERE item_id = ? AND duplicate_of = ?)", itemID, s.ItemID, s.Distance, itemID, s.ItemID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetDuplicateFlags returns every flag, newest first.
+func (r *DuplicateDBRepository) GetDuplicateFlags(ctx context.Context) ([]domain.DuplicateFlag, error) {
+	rows, err := r.QueryContext(ctx, `SELECT duplicate_flags.id, duplicate_flags.item_id, item.seller_id, duplicate_flags.duplicate_of, original.seller_id, duplicate_flags.distance, duplicate_flags.created_at
+		FROM duplicate_flags
+		JOIN items AS item ON item.id = duplicate_flags.item_id
+		JOIN items AS original ON original.id = duplicate_flags.duplicate_of
+		ORDER BY duplicate_flags.id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var flags []domain.DuplicateFlag
+	for rows.Next() {
+		var f domain.DuplicateFlag
+		if err := rows.Scan(&f.ID, &f.ItemID, &f.SellerID, &f.DuplicateOfID, &f.DuplicateOfSellerID, &f.Distance, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		flags = append(flags, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return flags, nil
+}
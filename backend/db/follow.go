@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+type FollowRepository interface {
+	SetFollow(ctx context.Context, follow domain.Follow) error
+	DeleteFollow(ctx context.Context, followerID int64, followeeID int64) error
+	GetFollowing(ctx context.Context, followerID int64) ([]domain.Follow, error)
+	GetFollowCounts(ctx context.Context, userID int64) (domain.FollowCounts, error)
+	GetNotifiedFollowerIDs(ctx context.Context, followeeID int64) ([]int64, error)
+	GetFeed(ctx context.Context, followerID int64, beforeListedAt string, beforeID int32, limit int) ([]domain.Item, error)
+}
+
+type FollowDBRepository struct {
+	*sql.DB
+}
+
+func NewFollowRepository(db *sql.DB) FollowRepository {
+	return &FollowDBRepository{DB: db}
+}
+
+// SetFollow follows a user, or updates whether an existing follow notifies.
+func (r *FollowDBRepository) SetFollow(ctx context.Context, follow domain.Follow) error {
+	_, err := r.ExecContext(ctx, "INSERT INTO follows (follower_id, followee_id, notify) VALUES (?, ?, ?) ON CONFLICT (follower_id, followee_id) DO UPDATE SET notify = excluded.notify",
+		follow.FollowerID, follow.FolloweeID, follow.Notify)
+	return err
+}
+
+// DeleteFollow unfollows a user. It returns sql.ErrNoRows if the user wasn't followed.
+func (r *FollowDBRepository) DeleteFollow(ctx context.Context, followerID int64, followeeID int64) error {
+	res, err := r.ExecContext(ctx, "DELETE FROM follows WHERE follower_id = ? AND followee_id = ?", followerID, followeeID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetFollowing returns the users followerID follows, most recently followed first.
+func (r *FollowDBRepository) GetFollowing(ctx context.Context, followerID int64) ([]domain.Follow, error) {
+	rows, err := r.QueryContext(ctx, "SELECT follows.follower_id, follows.followee_id, IFNULL(users.name, ''), follows.notify, follows.created_at FROM follows LEFT JOIN users ON users.id = follows.followee_id WHERE follows.follower_id = ? ORDER BY follows.created_at DESC, follows.followee_id DESC", followerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var follows []domain.Follow
+	for rows.Next() {
+		var f domain.Follow
+		if err := rows.Scan(&f.FollowerID, &f.FolloweeID, &f.FolloweeName, &f.Notify, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		follows = append(follows, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return follows, nil
+}
+
+func (r *FollowDBRepository) GetFollowCounts(ctx context.Context, userID int64) (domain.FollowCounts, error) {
+	var counts domain.FollowCounts
+	row := r.QueryRowContext(ctx, "SELECT (SELECT COUNT(*) FROM follows WHERE followee_id = ?), (SELECT COUNT(*) FROM follows WHERE follower_id = ?)", userID, userID)
+	err := row.Scan(&counts.Followers, &counts.Following)
+	return counts, err
+}
+
+// GetNotifiedFollowerIDs returns the followers of followeeID who want to be
+// notified of new listings.
+func (r *FollowDBRepository) GetNotifiedFollowerIDs(ctx context.Context, followeeID int64) ([]int64, error) {
+	rows, err := r.QueryContext(ctx, "SELECT follower_id FROM follows WHERE followee_id = ? AND notify = 1", followeeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// GetFeed returns up to limit items on sale by the users followerID follows,
+// most recently listed first. An empty beforeListedAt starts from the newest
+// item; otherwise the feed continues after the item beforeID listed at
+// beforeListedAt. Items without a listing time count as listed at their last
+// update, and are returned with that as their ListedAt so cursors can be
+// built from any item.
+func (r *FollowDBRepository) GetFeed(ctx context.Context, followerID int64, beforeListedAt string, beforeID int32, limit int) ([]domain.Item, error) {
+	const listedAt = "IFNULL(listed_at, updated_at)"
+	rows, err := r.QueryContext(ctx, "SELECT "+itemColumns+" FROM items WHERE status = ? AND seller_id IN (SELECT followee_id FROM follows WHERE follower_id = ?)"+
+		" AND (? = '' OR "+listedAt+" < ? OR ("+listedAt+" = ? AND id < ?)) ORDER BY "+listedAt+" DESC, id DESC LIMIT ?",
+		domain.ItemStatusOnSale, followerID, beforeListedAt, beforeListedAt, beforeListedAt, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	items, err := scanItems(rows)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		if items[i].ListedAt == "" {
+			items[i].ListedAt = items[i].UpdatedAt
+		}
+	}
+	return items, nil
+}
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+type IdentityRepository interface {
+	AddOIDCState(ctx context.Context, state domain.OIDCState, hash string, ttl time.Duration) error
+	UseOIDCState(ctx context.Context, hash string, userID int64) (domain.OIDCState, error)
+	GetIdentity(ctx context.Context, issuer string, subject string) (domain.Identity, error)
+	GetIdentities(ctx context.Context, userID int64) ([]domain.Identity, error)
+	AddIdentity(ctx context.Context, identity domain.Identity) (int64, error)
+	AddUserWithIdentity(ctx context.Context, user domain.User, identity domain.Identity) (int64, error)
+	DeleteIdentity(ctx context.Context, userID int64, id int64) error
+}
+
+type IdentityDBRepository struct {
+	*sql.DB
+}
+
+func NewIdentityRepository(db *sql.DB) IdentityRepository {
+	return &IdentityDBRepository{DB: db}
+}
+
+func (r *IdentityDBRepository) AddOIDCState(ctx context.Context, state domain.OIDCState, hash string, ttl time.Duration) error {
+	var userID interface{}
+	if state.UserID != 0 {
+		userID = state.UserID
+	}
+	_, err := r.ExecContext(ctx, "INSERT INTO oidc_states (state_hash, nonce, code_verifier, user_id, expires_at) VALUES (?, ?, ?, ?, DATETIME('now', 'localtime', ?))", hash, state.Nonce, state.CodeVerifier, userID, ttlModifier(ttl))
+	return err
+}
+
+// UseOIDCState uses up the state with the hash of a state token that was
+// started by userID, 0 for a login. It returns sql.ErrNoRows when there is no
+// such unused, unexpired state.
+func (r *IdentityDBRepository) UseOIDCState(ctx context.Context, hash string, userID int64) (domain.OIDCState, error) {
+	tx, err := r.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return domain.OIDCState{}, err
+	}
+
+	var state domain.OIDCState
+	row := tx.QueryRowContext(ctx, "SELECT id, nonce, code_verifier, IFNULL(user_id, 0) FROM oidc_states WHERE state_hash = ? AND IFNULL(user_id, 0) = ? AND used_at IS NULL AND expires_at > DATETIME('now', 'localtime')", hash, userID)
+	if err := row.Scan(&state.ID, &state.Nonce, &state.CodeVerifier, &state.UserID); err != nil {
+		tx.Rollback()
+		return domain.OIDCState{}, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE oidc_states SET used_at = DATETIME('now', 'localtime') WHERE id = ?", state.ID); err != nil {
+		tx.Rollback()
+		return domain.OIDCState{}, err
+	}
+
+	return state, tx.Commit()
+}
+
+func (r *IdentityDBRepository) GetIdentity(ctx context.Context, issuer string, subject string) (domain.Identity, error) {
+	row := r.QueryRowContext(ctx, "SELECT id, user_id, issuer, subject, IFNULL(email, ''), created_at FROM user_identities WHERE issuer = ? AND subject = ?", issuer, subject)
+
+	var identity domain.Identity
+	return identity, row.Scan(&identity.ID, &identity.UserID, &identity.Issuer, &identity.Subject, &identity.Email, &identity.CreatedAt)
+}
+
+func (r *IdentityDBRepository) GetIdentities(ctx context.Context, userID int64) ([]domain.Identity, error) {
+	rows, err := r.QueryContext(ctx, "SELECT id, user_id, issuer, subject, IFNULL(email, ''), created_at FROM user_identities WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []domain.Identity
+	for rows.Next() {
+		var identity domain.Identity
+		if err := rows.Scan(&identity.ID, &identity.UserID, &identity.Issuer, &identity.Subject, &identity.Email, &identity.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+func (r *IdentityDBRepository) AddIdentity(ctx context.Context, identity domain.Identity) (int64, error) {
+	res, err := r.ExecContext(ctx, "INSERT INTO user_identities (user_id, issuer, subject, email) VALUES (?, ?, ?, ?)", identity.UserID, identity.Issuer, identity.Subject, identity.Email)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// AddUserWithIdentity creates the user a new identity signs in as.
+func (r *IdentityDBRepository) AddUserWithIdentity(ctx context.Context, user domain.User, identity domain.Identity) (int64, error) {
+	tx, err := r.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return 0, err
+	}
+
+	if user.Role == "" {
+		user.Role = domain.RoleUser
+	}
+	res, err := tx.ExecContext(ctx, "INSERT INTO users (name, password, role, email, created_at) VALUES (?, ?, ?, ?, DATETIME('now', 'localtime'))", user.Name, user.Password, user.Role, user.Email)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	userID, err := res.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO user_identities (user_id, issuer, subject, email) VALUES (?, ?, ?, ?)", userID, identity.Issuer, identity.Subject, identity.Email); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return userID, tx.Commit()
+}
+
+// DeleteIdentity unlinks an identity of a user, returning sql.ErrNoRows if the
+// user has no such identity.
+func (r *IdentityDBRepository) DeleteIdentity(ctx context.Context, userID int64, id int64) error {
+	res, err := r.ExecContext(ctx, "DELETE FROM user_identities WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
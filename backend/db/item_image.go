@@ -0,0 +1,173 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+// An item's primary image is referenced from items.image_hash, so every
+// existing reader keeps working; any further images are referenced from
+// item_images starting at position 1.
+
+// GetItemImageKey returns the blob store key of the n-th image of an item in
+// the given size, 0 being the primary image. Keys are content hashes, so they
+// change whenever the image does.
+func (r *ItemDBRepository) GetItemImageKey(ctx context.Context, itemID int32, n int, size domain.ImageSize) (string, error) {
+	var hash string
+	if n == 0 {
+		primary, err := r.primaryImageHash(ctx, r.DB, itemID)
+		if err != nil {
+			return "", err
+		}
+		if primary == "" {
+			return "", sql.ErrNoRows
+		}
+		hash = primary
+	} else {
+		row := r.QueryRowContext(ctx, "SELECT image_hash FROM item_images WHERE item_id = ? AND position = ?", itemID, n)
+		if err := row.Scan(&hash); err != nil {
+			return "", err
+		}
+	}
+
+	return r.imageVariantKey(ctx, hash, size)
+}
+
+// GetImageVariantKey returns the key of the image stored under hash in the
+// given size, for images that belong to no item such as avatars.
+func (r *ItemDBRepository) GetImageVariantKey(ctx context.Context, hash string, size domain.ImageSize) (string, error) {
+	return r.imageVariantKey(ctx, hash, size)
+}
+
+// AddImageVariants links the resized variants of an image to its key.
+func (r *ItemDBRepository) AddImageVariants(ctx context.Context, hash string, variants map[domain.ImageSize]string) error {
+	tx, err := r.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return err
+	}
+
+	for size, variant := range variants {
+		if _, err := tx.ExecContext(ctx, "INSERT OR REPLACE INTO image_variants (image_hash, size, variant_hash) VALUES (?, ?, ?)", hash, size, variant); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// AddImagePlaceholder stores the placeholder colour of the image stored under hash.
+func (r *ItemDBRepository) AddImagePlaceholder(ctx context.Context, hash string, color string) error {
+	_, err := r.ExecContext(ctx, "INSERT OR REPLACE INTO image_placeholders (image_hash, color) VALUES (?, ?)", hash, color)
+	return err
+}
+
+// imageVariantKey returns the key of the image stored under hash in the given
+// size. Images uploaded before variants existed are served as they are.
+func (r *ItemDBRepository) imageVariantKey(ctx context.Context, hash string, size domain.ImageSize) (string, error) {
+	if size == domain.ImageSizeFull {
+		return hash, nil
+	}
+
+	row := r.QueryRowContext(ctx, "SELECT variant_hash FROM image_variants WHERE image_hash = ? AND size = ?", hash, size)
+
+	var variant string
+	switch err := row.Scan(&variant); {
+	case err == nil:
+		return variant, nil
+	case errors.Is(err, sql.ErrNoRows):
+		return hash, nil
+	default:
+		return "", err
+	}
+}
+
+func (r *ItemDBRepository) CountItemImages(ctx context.Context, itemID int32) (int, error) {
+	row := r.QueryRowContext(ctx, "SELECT 1 + (SELECT COUNT(*) FROM item_images WHERE item_id = ?) FROM items WHERE id = ?", itemID, itemID)
+
+	var count int
+	return count, row.Scan(&count)
+}
+
+// GetItemImageHashes returns the keys of all images of an item in display order, primary first.
+func (r *ItemDBRepository) GetItemImageHashes(ctx context.Context, itemID int32) ([]string, error) {
+	primary, err := r.primaryImageHash(ctx, r.DB, itemID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.QueryContext(ctx, "SELECT image_hash FROM item_images WHERE item_id = ? ORDER BY position", itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hashes := []string{primary}
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// SetItemImages replaces all images of an item with the stored images of
+// hashes, the first one becoming the primary image. Replacing the primary
+// image is recorded as a revision just like any other edit, and any change
+// of the images counts as an update of the item.
+func (r *ItemDBRepository) SetItemImages(ctx context.Context, itemID int32, hashes []string, editorID int64) error {
+	tx, err := r.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return err
+	}
+
+	var old domain.Item
+	row := tx.QueryRowContext(ctx, "SELECT id, name, price, description, category_id FROM items WHERE id = ?", itemID)
+	if err := row.Scan(&old.ID, &old.Name, &old.Price, &old.Description, &old.CategoryID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if old.ImageHash, err = r.primaryImageHash(ctx, tx, itemID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if old.ImageHash != hashes[0] {
+		if _, err := tx.ExecContext(ctx, "UPDATE items SET image_hash = ? WHERE id = ?", hashes[0], itemID); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		item := old
+		item.ImageHash = hashes[0]
+		if err := addItemRevision(ctx, tx, old, item, editorID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE items SET updated_at = DATETIME('now', 'localtime') WHERE id = ?", itemID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM item_images WHERE item_id = ?", itemID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for i, hash := range hashes[1:] {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO item_images (item_id, position, image_hash) VALUES (?, ?, ?)", itemID, i+1, hash); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+// addItemRevision stores old as a revision of item if the edit changed anything.
+func addItemRevision(ctx context.Context, tx *sql.Tx, old domain.Item, item domain.Item, editorID int64) error {
+	changes := diffItem(old, item)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO item_revisions (item_id, editor_id, name, price, description, category_id, image_hash, changes) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		item.ID, editorID, old.Name, old.Price, old.Description, old.CategoryID, old.ImageHash, string(encoded))
+	return err
+}
+
+func diffItem(old domain.Item, item domain.Item) []domain.FieldChange {
+	var changes []domain.FieldChange
+	if old.Name != item.Name {
+		changes = append(changes, domain.FieldChange{Field: "name", Old: old.Name, New: item.Name})
+	}
+	if old.Price != item.Price {
+		changes = append(changes, domain.FieldChange{Field: "price", Old: strconv.FormatInt(old.Price, 10), New: strconv.FormatInt(item.Price, 10)})
+	}
+	if old.Description != item.Description {
+		changes = append(changes, domain.FieldChange{Field: "description", Old: old.Description, New: item.Description})
+	}
+	if old.CategoryID != item.CategoryID {
+		changes = append(changes, domain.FieldChange{Field: "category_id", Old: strconv.FormatInt(old.CategoryID, 10), New: strconv.FormatInt(item.CategoryID, 10)})
+	}
+	if old.ImageHash != item.ImageHash {
+		changes = append(changes, domain.FieldChange{Field: "image", Old: old.ImageHash, New: item.ImageHash})
+	}
+	return changes
+}
+
+// GetItemRevisions returns the revisions of an item, oldest first.
+func (r *ItemDBRepository) GetItemRevisions(ctx context.Context, itemID int32) ([]domain.ItemRevision, error) {
+	rows, err := r.QueryContext(ctx, "SELECT id, item_id, editor_id, name, price, description, category_id, image_hash, changes, created_at FROM item_revisions WHERE item_id = ? ORDER BY id", itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []domain.ItemRevision
+	for rows.Next() {
+		var rev domain.ItemRevision
+		var changes string
+		if err := rows.Scan(&rev.ID, &rev.ItemID, &rev.EditorID, &rev.Name, &rev.Price, &rev.Description, &rev.CategoryID, &rev.ImageHash, &changes, &rev.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(changes), &rev.Changes); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+func (r *ItemDBRepository) GetItemRevision(ctx context.Context, itemID int32, revisionID int64) (domain.ItemRevision, error) {
+	row := r.QueryRowContext(ctx, "SELECT id, item_id, editor_id, name, price, description, category_id, image_hash, changes, created_at FROM item_revisions WHERE item_id = ? AND id = ?", itemID, revisionID)
+
+	var rev domain.ItemRevision
+	var changes string
+	if err := row.Scan(&rev.ID, &rev.ItemID, &rev.EditorID, &rev.Name, &rev.Price, &rev.Description, &rev.CategoryID, &rev.ImageHash, &changes, &rev.CreatedAt); err != nil {
+		return domain.ItemRevision{}, err
+	}
+	return rev, json.Unmarshal([]byte(changes), &rev.Changes)
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+type LoginAttemptRepository interface {
+	AddLoginAttempt(ctx context.Context, attempt domain.LoginAttempt) (int64, error)
+	SetLoginAttemptSucceeded(ctx context.Context, id int64) error
+	DeleteLoginAttempt(ctx context.Context, id int64) error
+	GetAccountFailures(ctx context.Context, userID int64, login string, beforeID int64, window time.Duration) (domain.LoginFailures, error)
+	GetIPFailures(ctx context.Context, ip string, beforeID int64, window time.Duration) (domain.LoginFailures, error)
+}
+
+type LoginAttemptDBRepository struct {
+	*sql.DB
+}
+
+func NewLoginAttemptRepository(db *sql.DB) LoginAttemptRepository {
+	return &LoginAttemptDBRepository{DB: db}
+}
+
+// AddLoginAttempt records an attempt and returns its ID.
+func (r *LoginAttemptDBRepository) AddLoginAttempt(ctx context.Context, attempt domain.LoginAttempt) (int64, error) {
+	var userID sql.NullInt64
+	if attempt.UserID != 0 {
+		userID = sql.NullInt64{Int64: attempt.UserID, Valid: true}
+	}
+	res, err := r.ExecContext(ctx, "INSERT INTO login_attempts (user_id, login, ip, success) VALUES (?, ?, ?, ?)", userID, attempt.Login, attempt.IP, attempt.Success)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (r *LoginAttemptDBRepository) SetLoginAttemptSucceeded(ctx context.Context, id int64) error {
+	_, err := r.ExecContext(ctx, "UPDATE login_attempts SET success = 1 WHERE id = ?", id)
+	return err
+}
+
+func (r *LoginAttemptDBRepository) DeleteLoginAttempt(ctx context.Context, id int64) error {
+	_, err := r.ExecContext(ctx, "DELETE FROM login_attempts WHERE id = ?", id)
+	return err
+}
+
+// GetAccountFailures counts the failed logins to an account within window
+// since its last successful login, among the attempts recorded before
+// beforeID. Attempts naming no account are counted by the login they tried,
+// so unknown accounts are throttled just like real ones.
+func (r *LoginAttemptDBRepository) GetAccountFailures(ctx context.Context, userID int64, login string, beforeID int64, window time.Duration) (domain.LoginFailures, error) {
+	if userID == 0 {
+		return r.getFailures(ctx, beforeID, window, "user_id IS NULL AND login = ? COLLATE NOCASE", login)
+	}
+	return r.getFailures(ctx, beforeID, window, "user_id = ? AND id > IFNULL((SELECT MAX(id) FROM login_attempts WHERE user_id = ? AND success = 1), 0)", userID, userID)
+}
+
+// GetIPFailures counts the failed logins from ip within window among the
+// attempts recorded before beforeID. Successful logins don't reset the
+// count, as an attacker may own one of the accounts.
+func (r *LoginAttemptDBRepository) GetIPFailures(ctx context.Context, ip string, beforeID int64, window time.Duration) (domain.LoginFailures, error) {
+	return r.getFailures(ctx, beforeID, window, "ip = ?", ip)
+}
+
+// getFailures counts the failed attempts before beforeID within window matching cond.
+func (r *LoginAttemptDBRepository) getFailures(ctx context.Context, beforeID int64, window time.Duration, cond string, args ...interface{}) (domain.LoginFailures, error) {
+	args = append(args, beforeID, fmt.Sprintf("-%d seconds", int64(window/time.Second)))
+
+	row := r.QueryRowContext(ctx, "SELECT COUNT(*), IFNULL(MAX(created_at), '') FROM login_attempts WHERE "+cond+" AND id < ? AND success = 0 AND created_at > DATETIME('now', 'localtime', ?)", args...)
+
+	var failures domain.LoginFailures
+	return failures, row.Scan(&failures.Count, &failures.LastAt)
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+type NotificationRepository interface {
+	AddNotification(ctx context.Context, notification domain.Notification) error
+	GetNotifications(ctx context.Context, userID int64) ([]domain.Notification, error)
+	MarkNotificationRead(ctx context.Context, userID int64, id int64) error
+	MarkAllNotificationsRead(ctx context.Context, userID int64) error
+}
+
+type NotificationDBRepository struct {
+	*sql.DB
+}
+
+func NewNotificationRepository(db *sql.DB) NotificationRepository {
+	return &NotificationDBRepository{DB: db}
+}
+
+func (r *NotificationDBRepository) AddNotification(ctx context.Context, notification domain.Notification) error {
+	_, err := r.ExecContext(ctx, "INSERT INTO notifications (user_id, type, item_id, message) VALUES (?, ?, ?, ?)",
+		notification.UserID, notification.Type, notification.ItemID, notification.Message)
+	return err
+}
+
+func (r *NotificationDBRepository) GetNotifications(ctx context.Context, userID int64) ([]domain.Notification, error) {
+	rows, err := r.QueryContext(ctx, "SELECT id, user_id, type, item_id, message, is_read, created_at FROM notifications WHERE user_id = ? ORDER BY id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []domain.Notification
+	for rows.Next() {
+		var n domain.Notification
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Type, &n.ItemID, &n.Message, &n.IsRead, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+// MarkNotificationRead marks a notification of a user as read, returning
+// sql.ErrNoRows if the user has no such notification.
+func (r *NotificationDBRepository) MarkNotificationRead(ctx context.Context, userID int64, id int64) error {
+	res, err := r.ExecContext(ctx, "UPDATE notifications SET is_read = 1 WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *NotificationDBRepository) MarkAllNotificationsRead(ctx context.Context, userID int64) error {
+	_, err := r.ExecContext(ctx, "UPDATE notifications SET is_read = 1 WHERE user_id = ? AND is_read = 0", userID)
+	return err
+}
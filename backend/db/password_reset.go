@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type PasswordResetRepository interface {
+	AddPasswordReset(ctx context.Context, userID int64, ip string, hash string, ttl time.Duration) error
+	CountPasswordResets(ctx context.Context, userID int64, ip string, window time.Duration) (int, int, error)
+	GetPasswordResetUserID(ctx context.Context, hash string) (int64, error)
+	ResetPassword(ctx context.Context, hash string, passwordHash string) (int64, error)
+}
+
+type PasswordResetDBRepository struct {
+	*sql.DB
+}
+
+func NewPasswordResetRepository(db *sql.DB) PasswordResetRepository {
+	return &PasswordResetDBRepository{DB: db}
+}
+
+// AddPasswordReset records a reset token issued to userID at the request of ip.
+func (r *PasswordResetDBRepository) AddPasswordReset(ctx context.Context, userID int64, ip string, hash string, ttl time.Duration) error {
+	_, err := r.ExecContext(ctx, "INSERT INTO password_resets (user_id, ip, token_hash, expires_at, created_at) VALUES (?, ?, ?, DATETIME('now', 'localtime', ?), DATETIME('now', 'localtime'))", userID, ip, hash, ttlModifier(ttl))
+	return err
+}
+
+// CountPasswordResets counts the reset tokens issued within window to userID
+// and at the request of ip.
+func (r *PasswordResetDBRepository) CountPasswordResets(ctx context.Context, userID int64, ip string, window time.Duration) (int, int, error) {
+	row := r.QueryRowContext(ctx, `SELECT IFNULL(SUM(user_id = ?), 0), IFNULL(SUM(ip = ?), 0) FROM password_resets
+		WHERE (user_id = ? OR ip = ?) AND created_at > DATETIME('now', 'localtime', ?)`, userID, ip, userID, ip, fmt.Sprintf("-%d seconds", int64(window/time.Second)))
+
+	var account, fromIP int
+	return account, fromIP, row.Scan(&account, &fromIP)
+}
+
+// GetPasswordResetUserID returns the user a reset token was issued to, or
+// sql.ErrNoRows when the token is unknown, expired or already used.
+func (r *PasswordResetDBRepository) GetPasswordResetUserID(ctx context.Context, hash string) (int64, error) {
+	row := r.QueryRowContext(ctx, "SELECT user_id FROM password_resets WHERE token_hash = ? AND used_at IS NULL AND expires_at > DATETIME('now', 'localtime')", hash)
+
+	var userID int64
+	return userID, row.Scan(&userID)
+}
+
+// ResetPassword sets the password of the user a reset token was issued to and
+// returns the user's ID. It returns sql.ErrNoRows when the token is unknown,
+// expired or already used. Every other outstanding token of the user is
+// used up as well.
+func (r *PasswordResetDBRepository) ResetPassword(ctx context.Context, hash string, passwordHash string) (int64, error) {
+	tx, err := r.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return 0, err
+	}
+
+	var userID int64
+	row := tx.QueryRowContext(ctx, "SELECT user_id FROM password_resets WHERE token_hash = ? AND used_at IS NULL AND expires_at > DATETIME('now', 'localtime')", hash)
+	if err := row.Scan(&userID); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE password_resets SET used_at = DATETIME('now', 'localtime') WHERE user_id = ? AND used_at IS NULL", userID); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET password = ? WHERE id = ?", passwordHash, userID); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return userID, tx.Commit()
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/blob"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/imageproc"
+)
+
+// BackfillPlaceholders computes the placeholder colour of every primary item
+// image that has none yet, e.g. the ones uploaded before placeholders existed.
+// Images that can't be decoded are skipped. It returns the number of
+// placeholders added.
+func BackfillPlaceholders(ctx context.Context, db *sql.DB, store blob.Store) (int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT DISTINCT image_hash FROM items WHERE image_hash IS NOT NULL AND image_hash != '' AND image_hash NOT IN (SELECT image_hash FROM image_placeholders)")
+	if err != nil {
+		return 0, err
+	}
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		hashes = append(hashes, hash)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	repo := &ItemDBRepository{DB: db, Blobs: store}
+	added := 0
+	for _, hash := range hashes {
+		// The thumbnail gives the same colour for a fraction of the decoding work
+		key, err := repo.imageVariantKey(ctx, hash, domain.ImageSizeThumb)
+		if err != nil {
+			return added, err
+		}
+		image, err := repo.GetImage(ctx, key)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return added, err
+		}
+
+		color, err := imageproc.DominantColor(image)
+		if err != nil {
+			continue
+		}
+		if err := repo.AddImagePlaceholder(ctx, hash, color); err != nil {
+			return added, err
+		}
+		added++
+	}
+
+	return added, nil
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+type PriceWatchRepository interface {
+	SetPriceWatch(ctx context.Context, watch domain.PriceWatch) error
+	DeletePriceWatch(ctx context.Context, userID int64, itemID int32) error
+	GetCrossedPriceWatches(ctx context.Context, itemID int32, oldPrice int64, newPrice int64) ([]domain.PriceWatch, error)
+}
+
+type PriceWatchDBRepository struct {
+	*sql.DB
+}
+
+func NewPriceWatchRepository(db *sql.DB) PriceWatchRepository {
+	return &PriceWatchDBRepository{DB: db}
+}
+
+func (r *PriceWatchDBRepository) SetPriceWatch(ctx context.Context, watch domain.PriceWatch) error {
+	_, err := r.ExecContext(ctx, "INSERT INTO price_watch (user_id, item_id, threshold) VALUES (?, ?, ?) ON CONFLICT (user_id, item_id) DO UPDATE SET threshold = excluded.threshold",
+		watch.UserID, watch.ItemID, watch.Threshold)
+	return err
+}
+
+func (r *PriceWatchDBRepository) DeletePriceWatch(ctx context.Context, userID int64, itemID int32) error {
+	_, err := r.ExecContext(ctx, "DELETE FROM price_watch WHERE user_id = ? AND item_id = ?", userID, itemID)
+	return err
+}
+
+// GetCrossedPriceWatches returns the watches whose threshold lies in [newPrice, oldPrice),
+// i.e. the ones crossed by this price drop, so each watcher is alerted once per drop.
+func (r *PriceWatchDBRepository) GetCrossedPriceWatches(ctx context.Context, itemID int32, oldPrice int64, newPrice int64) ([]domain.PriceWatch, error) {
+	rows, err := r.QueryContext(ctx, "SELECT user_id, item_id, threshold FROM price_watch WHERE item_id = ? AND threshold < ? AND threshold >= ?", itemID, oldPrice, newPrice)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var watches []domain.PriceWatch
+	for rows.Next() {
+		var w domain.PriceWatch
+		if err := rows.Scan(&w.UserID, &w.ItemID, &w.Threshold); err != nil {
+			return nil, err
+		}
+		watches = append(watches, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return watches, nil
+}
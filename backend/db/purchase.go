@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+type PurchaseRepository interface {
+	AddPurchase(ctx context.Context, purchase domain.Purchase) error
+	GetPurchaseByItemID(ctx context.Context, itemID int32) (domain.Purchase, error)
+	GetPurchase(ctx context.Context, id int64) (domain.Purchase, error)
+	GetUserPurchases(ctx context.Context, userID int64) ([]domain.Purchase, error)
+}
+
+type PurchaseDBRepository struct {
+	*sql.DB
+}
+
+func NewPurchaseRepository(db *sql.DB) PurchaseRepository {
+	return &PurchaseDBRepository{DB: db}
+}
+
+func (r *PurchaseDBRepository) AddPurchase(ctx context.Context, purchase domain.Purchase) error {
+	_, err := r.ExecContext(ctx, "INSERT INTO purchases (item_id, buyer_id, seller_id, price) VALUES (?, ?, ?, ?)",
+		purchase.ItemID, purchase.BuyerID, purchase.SellerID, purchase.Price)
+	return err
+}
+
+func (r *PurchaseDBRepository) GetPurchaseByItemID(ctx context.Context, itemID int32) (domain.Purchase, error) {
+	row := r.QueryRowContext(ctx, "SELECT id, item_id, buyer_id, seller_id, price, created_at FROM purchases WHERE item_id = ? ORDER BY id DESC LIMIT 1", itemID)
+
+	var p domain.Purchase
+	return p, row.Scan(&p.ID, &p.ItemID, &p.BuyerID, &p.SellerID, &p.Price, &p.CreatedAt)
+}
+
+func (r *PurchaseDBRepository) GetPurchase(ctx context.Context, id int64) (domain.Purchase, error) {
+	row := r.QueryRowContext(ctx, "SELECT id, item_id, buyer_id, seller_id, price, created_at FROM purchases WHERE id = ?", id)
+
+	var p domain.Purchase
+	return p, row.Scan(&p.ID, &p.ItemID, &p.BuyerID, &p.SellerID, &p.Price, &p.CreatedAt)
+}
+
+// GetUserPurchases returns the purchases a user bought or sold in, newest first.
+func (r *PurchaseDBRepository) GetUserPurchases(ctx context.Context, userID int64) ([]domain.Purchase, error) {
+	rows, err := r.QueryContext(ctx, "SELECT id, item_id, buyer_id, seller_id, price, created_at FROM purchases WHERE buyer_id = ? OR seller_id = ? ORDER BY id DESC", userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var purchases []domain.Purchase
+	for rows.Next() {
+		var p domain.Purchase
+		if err := rows.Scan(&p.ID, &p.ItemID, &p.BuyerID, &p.SellerID, &p.Price, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		purchases = append(purchases, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return purchases, nil
+}
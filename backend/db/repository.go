@@ -3,16 +3,25 @@ package db
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/blob"
 	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
 )
 
 type UserRepository interface {
 	AddUser(ctx context.Context, user domain.User) (int64, error)
 	GetUser(ctx context.Context, id int64) (domain.User, error)
+	GetUserByName(ctx context.Context, name string) (domain.User, error)
 	UpdateBalance(ctx context.Context, id int64, balance int64) error
+	SetUserRole(ctx context.Context, id int64, role domain.Role) error
+	UpdatePassword(ctx context.Context, id int64, hash string) error
+	GetProfile(ctx context.Context, id int64) (domain.Profile, error)
+	UpdateProfile(ctx context.Context, profile domain.Profile) error
+	SetAvatar(ctx context.Context, id int64, hash string) error
 }
 
 type UserDBRepository struct {
@@ -30,7 +39,10 @@ func (r *UserDBRepository) AddUser(ctx context.Context, user domain.User) (int64
 		return 0, err
 	}
 
-	if _, err := tx.ExecContext(ctx, "INSERT INTO users (name, password) VALUES (?, ?)", user.Name, user.Password); err != nil {
+	if user.Role == "" {
+		user.Role = domain.RoleUser
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO users (name, password, role, email, created_at) VALUES (?, ?, ?, ?, DATETIME('now', 'localtime'))", user.Name, user.Password, user.Role, user.Email); err != nil {
 		tx.Rollback()
 		return 0, echo.NewHTTPError(http.StatusConflict, err)
 	} else {
@@ -45,10 +57,19 @@ func (r *UserDBRepository) AddUser(ctx context.Context, user domain.User) (int64
 }
 
 func (r *UserDBRepository) GetUser(ctx context.Context, id int64) (domain.User, error) {
-	row := r.QueryRowContext(ctx, "SELECT * FROM users WHERE id = ?", id)
+	row := r.QueryRowContext(ctx, "SELECT id, name, password, balance, role, IFNULL(email, '') FROM users WHERE id = ?", id)
+
+	var user domain.User
+	return user, row.Scan(&user.ID, &user.Name, &user.Password, &user.Balance, &user.Role, &user.Email)
+}
+
+// GetUserByName looks a user up by name, ignoring case like the unique index
+// on names does.
+func (r *UserDBRepository) GetUserByName(ctx context.Context, name string) (domain.User, error) {
+	row := r.QueryRowContext(ctx, "SELECT id, name, password, balance, role, IFNULL(email, '') FROM users WHERE name = ? COLLATE NOCASE", name)
 
 	var user domain.User
-	return user, row.Scan(&user.ID, &user.Name, &user.Password, &user.Balance)
+	return user, row.Scan(&user.ID, &user.Name, &user.Password, &user.Balance, &user.Role, &user.Email)
 }
 
 func (r *UserDBRepository) UpdateBalance(ctx context.Context, id int64, balance int64) error {
@@ -58,62 +79,292 @@ func (r *UserDBRepository) UpdateBalance(ctx context.Context, id int64, balance
 	return nil
 }
 
+func (r *UserDBRepository) SetUserRole(ctx context.Context, id int64, role domain.Role) error {
+	result, err := r.ExecContext(ctx, "UPDATE users SET role = ? WHERE id = ?", role, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *UserDBRepository) UpdatePassword(ctx context.Context, id int64, hash string) error {
+	_, err := r.ExecContext(ctx, "UPDATE users SET password = ? WHERE id = ?", hash, id)
+	return err
+}
+
+func (r *UserDBRepository) GetProfile(ctx context.Context, id int64) (domain.Profile, error) {
+	row := r.QueryRowContext(ctx, "SELECT id, name, IFNULL(display_name, ''), IFNULL(bio, ''), IFNULL(location, ''), IFNULL(avatar_hash, ''), IFNULL(created_at, '') FROM users WHERE id = ?", id)
+
+	var profile domain.Profile
+	return profile, row.Scan(&profile.UserID, &profile.Name, &profile.DisplayName, &profile.Bio, &profile.Location, &profile.AvatarHash, &profile.JoinedAt)
+}
+
+// UpdateProfile sets the editable fields of a profile: the display name, bio
+// and location.
+func (r *UserDBRepository) UpdateProfile(ctx context.Context, profile domain.Profile) error {
+	_, err := r.ExecContext(ctx, "UPDATE users SET display_name = ?, bio = ?, location = ? WHERE id = ?", profile.DisplayName, profile.Bio, profile.Location, profile.UserID)
+	return err
+}
+
+// SetAvatar sets the image key of a user's avatar. An empty hash removes it.
+func (r *UserDBRepository) SetAvatar(ctx context.Context, id int64, hash string) error {
+	_, err := r.ExecContext(ctx, "UPDATE users SET avatar_hash = NULLIF(?, '') WHERE id = ?", hash, id)
+	return err
+}
+
+// renameDuplicateUserNames makes user names unique ignoring case, as the
+// users_name index requires, for databases created before it existed. The
+// oldest account keeps a name and the others get their ID appended.
+func renameDuplicateUserNames(ctx context.Context, db *sql.DB) error {
+	var exists int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'users'").Scan(&exists); err != nil {
+		return err
+	}
+	if exists == 0 {
+		return nil
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT id, name FROM users AS u
+		WHERE EXISTS (SELECT 1 FROM users WHERE name = u.name COLLATE NOCASE AND id < u.id)
+		ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	type user struct {
+		id   int64
+		name string
+	}
+	var duplicates []user
+	for rows.Next() {
+		var u user
+		if err := rows.Scan(&u.id, &u.name); err != nil {
+			rows.Close()
+			return err
+		}
+		duplicates = append(duplicates, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, u := range duplicates {
+		for suffix := u.id; ; suffix++ {
+			name := fmt.Sprintf("%s-%d", u.name, suffix)
+			res, err := db.ExecContext(ctx, "UPDATE users SET name = ? WHERE id = ? AND NOT EXISTS (SELECT 1 FROM users WHERE name = ? COLLATE NOCASE)", name, u.id, name)
+			if err != nil {
+				return err
+			}
+			if n, err := res.RowsAffected(); err != nil {
+				return err
+			} else if n > 0 {
+				break
+			}
+		}
+	}
+	return nil
+}
+
 type ItemRepository interface {
 	AddItem(ctx context.Context, item domain.Item) (domain.Item, error)
-	EditItem(ctx context.Context, item domain.Item) (domain.Item, error)
+	EditItem(ctx context.Context, item domain.Item, editorID int64) (domain.Item, error)
 	AddCategory(ctx context.Context, category domain.Category) (domain.Category, error)
 	GetItem(ctx context.Context, id int32) (domain.Item, error)
-	GetItemImage(ctx context.Context, id int32) ([]byte, error)
+	GetItemImageKey(ctx context.Context, itemID int32, n int, size domain.ImageSize) (string, error)
+	GetImageVariantKey(ctx context.Context, hash string, size domain.ImageSize) (string, error)
+	AddImageVariants(ctx context.Context, hash string, variants map[domain.ImageSize]string) error
+	AddImagePlaceholder(ctx context.Context, hash string, color string) error
+	GetItemImageHashes(ctx context.Context, itemID int32) ([]string, error)
+	CountItemImages(ctx context.Context, itemID int32) (int, error)
+	SetItemImages(ctx context.Context, itemID int32, hashes []string, editorID int64) error
+	PutImage(ctx context.Context, image []byte) (string, error)
+	GetImage(ctx context.Context, hash string) ([]byte, error)
 	GetOnSaleItems(ctx context.Context) ([]domain.Item, error)
 	GetItemsByUserID(ctx context.Context, userID int64) ([]domain.Item, error)
 	GetCategory(ctx context.Context, id int64) (domain.Category, error)
-	GetCategoryByName(ctx context.Context, name string) (domain.Category, error)
+	GetCategoryByName(ctx context.Context, parentID int64, name string) (domain.Category, error)
 	GetCategories(ctx context.Context) ([]domain.Category, error)
+	GetLastModified(ctx context.Context) (string, error)
 	GetItemByKeyword(ctx context.Context, keyword string) ([]domain.Item, error)
 	UpdateItemStatus(ctx context.Context, id int32, status domain.ItemStatus) error
 	GetItemsByCategory(ctx context.Context, categoryID int64) ([]domain.Item, error) // for category search page
+	GetItemsInCategoryTree(ctx context.Context, categoryID int64) ([]domain.Item, error)
+	GetCategoryPath(ctx context.Context, id int64) ([]domain.Category, error)
+	SetCategoryParent(ctx context.Context, id int64, parentID int64) error
+	RenameCategory(ctx context.Context, id int64, name string) error
+	MergeCategory(ctx context.Context, id int64, intoID int64) (int64, int64, error)
+	DeleteCategory(ctx context.Context, id int64) error
+	GetPriceHistory(ctx context.Context, itemID int32) ([]domain.PriceHistory, error)
+	GetItemRevisions(ctx context.Context, itemID int32) ([]domain.ItemRevision, error)
+	GetItemRevision(ctx context.Context, itemID int32, revisionID int64) (domain.ItemRevision, error)
 }
 
+// ItemDBRepository keeps item rows in the database and their images in Blobs.
 type ItemDBRepository struct {
 	*sql.DB
+	Blobs blob.Store
+}
+
+func NewItemRepository(db *sql.DB, blobs blob.Store) ItemRepository {
+	return &ItemDBRepository{DB: db, Blobs: blobs}
+}
+
+// itemColumns lists the columns read into domain.Item. The legacy image
+// column is left out so list queries never load image data.
+const itemColumns = "id, name, price, description, category_id, seller_id, image_hash, " +
+	"(SELECT color FROM image_placeholders WHERE image_placeholders.image_hash = items.image_hash), status, created_at, updated_at, listed_at"
+
+// setListedAt records the time an item is first put on sale. It takes the
+// new status as its argument; taking an item off sale and back on keeps the
+// original time.
+var setListedAt = fmt.Sprintf("listed_at = CASE WHEN listed_at IS NULL AND ? = %d THEN DATETIME('now', 'localtime') ELSE listed_at END", domain.ItemStatusOnSale)
+
+// backfillListedAt dates items put on sale without a listing time, by older
+// versions or by seed data, to their last update.
+func backfillListedAt(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "UPDATE items SET listed_at = updated_at WHERE listed_at IS NULL AND status IN (?, ?)", domain.ItemStatusOnSale, domain.ItemStatusSoldOut)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanItem(row rowScanner) (domain.Item, error) {
+	var item domain.Item
+	var imageHash, placeholder, listedAt sql.NullString
+	err := row.Scan(&item.ID, &item.Name, &item.Price, &item.Description, &item.CategoryID, &item.UserID, &imageHash, &placeholder, &item.Status, &item.CreatedAt, &item.UpdatedAt, &listedAt)
+	item.ImageHash = imageHash.String
+	item.Placeholder = placeholder.String
+	item.ListedAt = listedAt.String
+	return item, err
+}
+
+func scanItems(rows *sql.Rows) ([]domain.Item, error) {
+	defer rows.Close()
+
+	var items []domain.Item
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-func NewItemRepository(db *sql.DB) ItemRepository {
-	return &ItemDBRepository{DB: db}
+func (r *ItemDBRepository) PutImage(ctx context.Context, image []byte) (string, error) {
+	return r.Blobs.Put(ctx, image)
+}
+
+func (r *ItemDBRepository) GetImage(ctx context.Context, hash string) ([]byte, error) {
+	image, err := r.Blobs.Get(ctx, hash)
+	if errors.Is(err, blob.ErrNotFound) {
+		return nil, sql.ErrNoRows
+	}
+	return image, err
 }
 
 func (r *ItemDBRepository) AddItem(ctx context.Context, item domain.Item) (domain.Item, error) {
+	imageHash := item.ImageHash
+	if item.Image != nil {
+		hash, err := r.Blobs.Put(ctx, item.Image)
+		if err != nil {
+			return domain.Item{}, err
+		}
+		imageHash = hash
+	}
+
 	tx, err := r.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
 	if err != nil {
 		return domain.Item{}, err
 	}
 
-	if _, err := tx.ExecContext(ctx, "INSERT INTO items (name, price, description, category_id, seller_id, image, status) VALUES (?, ?, ?, ?, ?, ?, ?)", item.Name, item.Price, item.Description, item.CategoryID, item.UserID, item.Image, item.Status); err != nil {
+	result, err := tx.ExecContext(ctx, "INSERT INTO items (name, price, description, category_id, seller_id, image_hash, status) VALUES (?, ?, ?, ?, ?, ?, ?)", item.Name, item.Price, item.Description, item.CategoryID, item.UserID, imageHash, item.Status)
+	if err != nil {
 		tx.Rollback()
 		return domain.Item{}, echo.NewHTTPError(http.StatusConflict, err)
-	} else {
-		tx.Commit()
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return domain.Item{}, err
 	}
 
-	row := r.QueryRowContext(ctx, "SELECT * FROM items WHERE rowid = LAST_INSERT_ROWID()")
+	// The listing price is the first entry of the item's price history
+	if _, err := tx.ExecContext(ctx, "INSERT INTO item_price_history (item_id, price, changed_by) VALUES (?, ?, ?)", id, item.Price, item.UserID); err != nil {
+		tx.Rollback()
+		return domain.Item{}, err
+	}
 
-	var res domain.Item
-	return res, row.Scan(&res.ID, &res.Name, &res.Price, &res.Description, &res.CategoryID, &res.UserID, &res.Image, &res.Status, &res.CreatedAt, &res.UpdatedAt)
+	if err := tx.Commit(); err != nil {
+		return domain.Item{}, err
+	}
+
+	return scanItem(r.QueryRowContext(ctx, "SELECT "+itemColumns+" FROM items WHERE id = ?", id))
 }
 
-// Create an Edit Method
-func (r *ItemDBRepository) EditItem(ctx context.Context, item domain.Item) (domain.Item, error) {
+// EditItem overwrites the item and, in the same transaction, records the
+// previous state as a revision and any price change in the price history.
+func (r *ItemDBRepository) EditItem(ctx context.Context, item domain.Item, editorID int64) (domain.Item, error) {
+	// A new upload replaces the image; otherwise ImageHash, if set, selects an already stored one
+	if item.Image != nil {
+		hash, err := r.Blobs.Put(ctx, item.Image)
+		if err != nil {
+			return domain.Item{}, err
+		}
+		item.ImageHash = hash
+	}
+
 	// start a new transaction
 	tx, err := r.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
 	if err != nil {
 		return domain.Item{}, err
 	}
 
-	if _, err := r.ExecContext(ctx, "UPDATE items SET name = ?, price = ?, description = ?, category_id = ?, image = ?, status = ? WHERE id = ?", item.Name, item.Price, item.Description, item.CategoryID, item.Image, item.Status, item.ID); err != nil {
+	var old domain.Item
+	row := tx.QueryRowContext(ctx, "SELECT id, name, price, description, category_id FROM items WHERE id = ?", item.ID)
+	if err := row.Scan(&old.ID, &old.Name, &old.Price, &old.Description, &old.CategoryID); err != nil {
+		tx.Rollback()
+		return domain.Item{}, err
+	}
+	if old.ImageHash, err = r.primaryImageHash(ctx, tx, item.ID); err != nil {
+		tx.Rollback()
+		return domain.Item{}, err
+	}
+	if item.ImageHash == "" {
+		item.ImageHash = old.ImageHash
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE items SET name = ?, price = ?, description = ?, category_id = ?, image_hash = ?, status = ?, updated_at = DATETIME('now', 'localtime'), "+setListedAt+" WHERE id = ?", item.Name, item.Price, item.Description, item.CategoryID, item.ImageHash, item.Status, item.Status, item.ID); err != nil {
 		tx.Rollback()
 		return domain.Item{}, echo.NewHTTPError(http.StatusConflict, err)
 	}
 
+	// Record every price change so the history is never lost on edit
+	if old.Price != item.Price {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO item_price_history (item_id, price, changed_by) VALUES (?, ?, ?)", item.ID, item.Price, editorID); err != nil {
+			tx.Rollback()
+			return domain.Item{}, err
+		}
+	}
+
+	if err := addItemRevision(ctx, tx, old, item, editorID); err != nil {
+		tx.Rollback()
+		return domain.Item{}, err
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return domain.Item{}, err
@@ -123,160 +374,113 @@ func (r *ItemDBRepository) EditItem(ctx context.Context, item domain.Item) (doma
 }
 
 func (r *ItemDBRepository) GetItem(ctx context.Context, id int32) (domain.Item, error) {
-	row := r.QueryRowContext(ctx, "SELECT * FROM items WHERE id = ?", id)
-
-	var item domain.Item
-	return item, row.Scan(&item.ID, &item.Name, &item.Price, &item.Description, &item.CategoryID, &item.UserID, &item.Image, &item.Status, &item.CreatedAt, &item.UpdatedAt)
+	return scanItem(r.QueryRowContext(ctx, "SELECT "+itemColumns+" FROM items WHERE id = ?", id))
 }
 
-func (r *ItemDBRepository) GetItemImage(ctx context.Context, id int32) ([]byte, error) {
-	row := r.QueryRowContext(ctx, "SELECT image FROM items WHERE id = ?", id)
-
-	var image []byte
-	if err := row.Scan(&image); err != nil {
-		return nil, err
-	}
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
 
-	return image, nil
+// primaryImageHash returns the key of the item's primary image. It is empty
+// for items without one, and for legacy images still stored in the items row
+// until cmd/migrate-blobs has processed them.
+func (r *ItemDBRepository) primaryImageHash(ctx context.Context, q rowQuerier, itemID int32) (string, error) {
+	var hash string
+	err := q.QueryRowContext(ctx, "SELECT IFNULL(image_hash, '') FROM items WHERE id = ?", itemID).Scan(&hash)
+	return hash, err
 }
 
 func (r *ItemDBRepository) GetOnSaleItems(ctx context.Context) ([]domain.Item, error) {
-	rows, err := r.QueryContext(ctx, "SELECT * FROM items WHERE status = ? ORDER BY updated_at desc", domain.ItemStatusOnSale)
+	rows, err := r.QueryContext(ctx, "SELECT "+itemColumns+" FROM items WHERE status = ? ORDER BY updated_at desc", domain.ItemStatusOnSale)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var items []domain.Item
-	for rows.Next() {
-		var item domain.Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.Price, &item.Description, &item.CategoryID, &item.UserID, &item.Image, &item.Status, &item.CreatedAt, &item.UpdatedAt); err != nil {
-			return nil, err
-		}
-		items = append(items, item)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
+	return scanItems(rows)
 }
 
 func (r *ItemDBRepository) GetItemsByUserID(ctx context.Context, userID int64) ([]domain.Item, error) {
-	rows, err := r.QueryContext(ctx, "SELECT * FROM items WHERE seller_id = ?", userID)
+	rows, err := r.QueryContext(ctx, "SELECT "+itemColumns+" FROM items WHERE seller_id = ?", userID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var items []domain.Item
-	for rows.Next() {
-		var item domain.Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.Price, &item.Description, &item.CategoryID, &item.UserID, &item.Image, &item.Status, &item.CreatedAt, &item.UpdatedAt); err != nil {
-			return nil, err
-		}
-		items = append(items, item)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
+	return scanItems(rows)
 }
 
 func (r *ItemDBRepository) UpdateItemStatus(ctx context.Context, id int32, status domain.ItemStatus) error {
-	if _, err := r.ExecContext(ctx, "UPDATE items SET status = ? WHERE id = ?", status, id); err != nil {
+	if _, err := r.ExecContext(ctx, "UPDATE items SET status = ?, updated_at = DATETIME('now', 'localtime'), "+setListedAt+" WHERE id = ?", status, status, id); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (r *ItemDBRepository) GetCategory(ctx context.Context, id int64) (domain.Category, error) {
-	row := r.QueryRowContext(ctx, "SELECT * FROM category WHERE id = ?", id)
-
-	var cat domain.Category
-	return cat, row.Scan(&cat.ID, &cat.Name)
+	return scanCategory(r.QueryRowContext(ctx, "SELECT "+categoryColumns+" FROM category WHERE id = ?", id))
 }
 
 func (r *ItemDBRepository) AddCategory(ctx context.Context, category domain.Category) (domain.Category, error) {
-	var newCategory domain.Category
-
-	err := r.QueryRowContext(ctx, "INSERT INTO category (name) VALUES (?) RETURNING *", category.Name).Scan(&newCategory.ID, &newCategory.Name)
-	if err != nil {
-		return domain.Category{}, err
-	}
-
-	return newCategory, nil
+	parentID := sql.NullInt64{Int64: category.ParentID, Valid: category.ParentID != 0}
+	return scanCategory(r.QueryRowContext(ctx, "INSERT INTO category (name, parent_id, updated_at) VALUES (?, ?, DATETIME('now', 'localtime')) RETURNING "+categoryColumns, category.Name, parentID))
 }
 
 func (r *ItemDBRepository) GetCategories(ctx context.Context) ([]domain.Category, error) {
-	rows, err := r.QueryContext(ctx, "SELECT * FROM category")
+	rows, err := r.QueryContext(ctx, "SELECT "+categoryColumns+" FROM category")
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return scanCategories(rows)
+}
 
-	var cats []domain.Category
-	for rows.Next() {
-		var cat domain.Category
-		if err := rows.Scan(&cat.ID, &cat.Name); err != nil {
-			return nil, err
-		}
-		cats = append(cats, cat)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+// GetLastModified returns when an item or a category was last changed, empty
+// if none was.
+func (r *ItemDBRepository) GetLastModified(ctx context.Context) (string, error) {
+	var lastModified string
+	row := r.QueryRowContext(ctx, `SELECT IFNULL(MAX(updated_at), '') FROM (
+			SELECT MAX(updated_at) AS updated_at FROM items
+			UNION ALL SELECT MAX(updated_at) FROM category
+		)`)
+	if err := row.Scan(&lastModified); err != nil {
+		return "", err
 	}
-	return cats, nil
+	return lastModified, nil
 }
 
 func (r *ItemDBRepository) GetItemByKeyword(ctx context.Context, keyword string) ([]domain.Item, error) {
 	pattern := "%" + keyword + "%"
-	rows, err := r.QueryContext(ctx, "SELECT * FROM items WHERE name LIKE ?", pattern)
+	rows, err := r.QueryContext(ctx, "SELECT "+itemColumns+" FROM items WHERE name LIKE ?", pattern)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return scanItems(rows)
+}
 
-	var items []domain.Item
-	for rows.Next() {
-		var item domain.Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.Price, &item.Description, &item.CategoryID, &item.UserID, &item.Image, &item.Status, &item.CreatedAt, &item.UpdatedAt); err != nil {
-			return nil, err
-		}
-		items = append(items, item)
-	}
-	if err := rows.Err(); err != nil {
+// categories id page method
+func (r *ItemDBRepository) GetItemsByCategory(ctx context.Context, categoryID int64) ([]domain.Item, error) {
+	rows, err := r.QueryContext(ctx, "SELECT "+itemColumns+" FROM items WHERE category_id = ?", categoryID)
+	if err != nil {
 		return nil, err
 	}
-	return items, nil
-}
-
-func (r *ItemDBRepository) GetCategoryByName(ctx context.Context, name string) (domain.Category, error) {
-	row := r.QueryRowContext(ctx, "SELECT * FROM category WHERE name = ?", name)
-
-	var cat domain.Category
-	return cat, row.Scan(&cat.ID, &cat.Name)
+	return scanItems(rows)
 }
 
-// categories id page method
-func (r *ItemDBRepository) GetItemsByCategory(ctx context.Context, categoryID int64) ([]domain.Item, error) {
-	rows, err := r.QueryContext(ctx, "SELECT * FROM items WHERE category_id = ?", categoryID)
+func (r *ItemDBRepository) GetPriceHistory(ctx context.Context, itemID int32) ([]domain.PriceHistory, error) {
+	rows, err := r.QueryContext(ctx, "SELECT id, item_id, price, changed_by, created_at FROM item_price_history WHERE item_id = ? ORDER BY id", itemID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var items []domain.Item
+	var history []domain.PriceHistory
 	for rows.Next() {
-		var item domain.Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.Price, &item.Description, &item.CategoryID, &item.UserID, &item.Image, &item.Status, &item.CreatedAt, &item.UpdatedAt); err != nil {
+		var h domain.PriceHistory
+		if err := rows.Scan(&h.ID, &h.ItemID, &h.Price, &h.ChangedBy, &h.CreatedAt); err != nil {
 			return nil, err
 		}
-		items = append(items, item)
+		history = append(history, h)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
-	return items, nil
+	return history, nil
 }
 
 type OnsitePurchaseRepository interface {
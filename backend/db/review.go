@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+// ErrAlreadyReviewed is returned when a user reviews a purchase twice.
+var ErrAlreadyReviewed = errors.New("purchase was already reviewed")
+
+const reviewColumns = "reviews.id, reviews.purchase_id, purchases.item_id, reviews.reviewer_id, IFNULL(users.name, ''), reviews.reviewee_id, reviews.role, reviews.rating, IFNULL(reviews.comment, ''), reviews.created_at"
+
+const reviewJoins = " FROM reviews JOIN purchases ON purchases.id = reviews.purchase_id LEFT JOIN users ON users.id = reviews.reviewer_id"
+
+type ReviewRepository interface {
+	AddReview(ctx context.Context, review domain.Review) (domain.Review, error)
+	GetReviewsByPurchase(ctx context.Context, purchaseID int64) ([]domain.Review, error)
+	GetReviewsOfUser(ctx context.Context, userID int64, role domain.ReviewerRole) ([]domain.Review, error)
+	GetReviewedPurchaseIDs(ctx context.Context, reviewerID int64) (map[int64]bool, error)
+	GetRatingSummary(ctx context.Context, userID int64) (domain.RatingSummary, error)
+}
+
+type ReviewDBRepository struct {
+	*sql.DB
+}
+
+func NewReviewRepository(db *sql.DB) ReviewRepository {
+	return &ReviewDBRepository{DB: db}
+}
+
+// AddReview stores a review, returning ErrAlreadyReviewed if the reviewer
+// already reviewed the purchase.
+func (r *ReviewDBRepository) AddReview(ctx context.Context, review domain.Review) (domain.Review, error) {
+	res, err := r.ExecContext(ctx, "INSERT INTO reviews (purchase_id, reviewer_id, reviewee_id, role, rating, comment) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT (purchase_id, reviewer_id) DO NOTHING",
+		review.PurchaseID, review.ReviewerID, review.RevieweeID, review.Role, review.Rating, review.Comment)
+	if err != nil {
+		return domain.Review{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return domain.Review{}, err
+	} else if n == 0 {
+		return domain.Review{}, ErrAlreadyReviewed
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return domain.Review{}, err
+	}
+
+	return scanReview(r.QueryRowContext(ctx, "SELECT "+reviewColumns+reviewJoins+" WHERE reviews.id = ?", id))
+}
+
+func (r *ReviewDBRepository) GetReviewsByPurchase(ctx context.Context, purchaseID int64) ([]domain.Review, error) {
+	rows, err := r.QueryContext(ctx, "SELECT "+reviewColumns+reviewJoins+" WHERE reviews.purchase_id = ? ORDER BY reviews.id", purchaseID)
+	if err != nil {
+		return nil, err
+	}
+	return scanReviews(rows)
+}
+
+// GetReviewsOfUser returns the reviews a user received, newest first. A
+// non-empty role only returns the ones left by that side, e.g. ReviewerBuyer
+// for the reviews of the user as a seller.
+func (r *ReviewDBRepository) GetReviewsOfUser(ctx context.Context, userID int64, role domain.ReviewerRole) ([]domain.Review, error) {
+	rows, err := r.QueryContext(ctx, "SELECT "+reviewColumns+reviewJoins+" WHERE reviews.reviewee_id = ? AND (? = '' OR reviews.role = ?) ORDER BY reviews.id DESC", userID, role, role)
+	if err != nil {
+		return nil, err
+	}
+	return scanReviews(rows)
+}
+
+// GetReviewedPurchaseIDs returns the IDs of the purchases a user reviewed.
+func (r *ReviewDBRepository) GetReviewedPurchaseIDs(ctx context.Context, reviewerID int64) (map[int64]bool, error) {
+	rows, err := r.QueryContext(ctx, "SELECT purchase_id FROM reviews WHERE reviewer_id = ?", reviewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviewed := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		reviewed[id] = true
+	}
+	return reviewed, rows.Err()
+}
+
+func (r *ReviewDBRepository) GetRatingSummary(ctx context.Context, userID int64) (domain.RatingSummary, error) {
+	row := r.QueryRowContext(ctx, "SELECT IFNULL(AVG(rating), 0), COUNT(*) FROM reviews WHERE reviewee_id = ?", userID)
+
+	var summary domain.RatingSummary
+	return summary, row.Scan(&summary.Average, &summary.Count)
+}
+
+func scanReview(row rowScanner) (domain.Review, error) {
+	var review domain.Review
+	err := row.Scan(&review.ID, &review.PurchaseID, &review.ItemID, &review.ReviewerID, &review.ReviewerName, &review.RevieweeID, &review.Role, &review.Rating, &review.Comment, &review.CreatedAt)
+	return review, err
+}
+
+func scanReviews(rows *sql.Rows) ([]domain.Review, error) {
+	defer rows.Close()
+
+	var reviews []domain.Review
+	for rows.Next() {
+		review, err := scanReview(rows)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, review)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return reviews, nil
+}
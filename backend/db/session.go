@@ -0,0 +1,210 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+type SessionRepository interface {
+	AddSession(ctx context.Context, session domain.Session) (int64, error)
+	GetSessions(ctx context.Context, userID int64) ([]domain.Session, error)
+	TouchSession(ctx context.Context, id int64) (bool, error)
+	RevokeSession(ctx context.Context, userID int64, id int64) error
+	RevokeUserSessions(ctx context.Context, userID int64, exceptID int64) error
+	AddRefreshToken(ctx context.Context, sessionID int64, hash string, ttl time.Duration) error
+	GetRefreshToken(ctx context.Context, hash string) (domain.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, id int64, sessionID int64, hash string, ttl time.Duration) error
+}
+
+type SessionDBRepository struct {
+	*sql.DB
+}
+
+func NewSessionRepository(db *sql.DB) SessionRepository {
+	return &SessionDBRepository{DB: db}
+}
+
+func (r *SessionDBRepository) AddSession(ctx context.Context, session domain.Session) (int64, error) {
+	res, err := r.ExecContext(ctx, "INSERT INTO sessions (user_id, user_agent, ip) VALUES (?, ?, ?)", session.UserID, session.UserAgent, session.IP)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetSessions returns the sessions of a user that can still be refreshed,
+// most recently used first.
+func (r *SessionDBRepository) GetSessions(ctx context.Context, userID int64) ([]domain.Session, error) {
+	rows, err := r.QueryContext(ctx, `SELECT id, user_id, user_agent, ip, created_at, last_seen_at FROM sessions
+		WHERE user_id = ? AND revoked_at IS NULL
+		AND EXISTS (SELECT 1 FROM refresh_tokens WHERE refresh_tokens.session_id = sessions.id AND used_at IS NULL AND expires_at > DATETIME('now', 'localtime'))
+		ORDER BY last_seen_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []domain.Session
+	for rows.Next() {
+		var session domain.Session
+		if err := rows.Scan(&session.ID, &session.UserID, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastSeenAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// TouchSession records that a session was just used and reports whether it
+// is still active. Unknown sessions are not active. last_seen_at is written
+// at most once a minute to spare a write on every request.
+func (r *SessionDBRepository) TouchSession(ctx context.Context, id int64) (bool, error) {
+	var active bool
+	if err := r.QueryRowContext(ctx, "SELECT revoked_at IS NULL FROM sessions WHERE id = ?", id).Scan(&active); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	if !active {
+		return false, nil
+	}
+
+	_, err := r.ExecContext(ctx, "UPDATE sessions SET last_seen_at = DATETIME('now', 'localtime') WHERE id = ? AND last_seen_at < DATETIME('now', 'localtime', '-1 minutes')", id)
+	return true, err
+}
+
+// RevokeSession revokes a session of a user, returning sql.ErrNoRows if the
+// user has no such active session.
+func (r *SessionDBRepository) RevokeSession(ctx context.Context, userID int64, id int64) error {
+	res, err := r.ExecContext(ctx, "UPDATE sessions SET revoked_at = DATETIME('now', 'localtime') WHERE id = ? AND user_id = ? AND revoked_at IS NULL", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RevokeUserSessions revokes every session of a user but exceptID, logging
+// them out on all other devices. An exceptID of 0 revokes them all.
+func (r *SessionDBRepository) RevokeUserSessions(ctx context.Context, userID int64, exceptID int64) error {
+	_, err := r.ExecContext(ctx, "UPDATE sessions SET revoked_at = DATETIME('now', 'localtime') WHERE user_id = ? AND id != ? AND revoked_at IS NULL", userID, exceptID)
+	return err
+}
+
+func (r *SessionDBRepository) AddRefreshToken(ctx context.Context, sessionID int64, hash string, ttl time.Duration) error {
+	_, err := r.ExecContext(ctx, "INSERT INTO refresh_tokens (session_id, token_hash, expires_at) VALUES (?, ?, DATETIME('now', 'localtime', ?))", sessionID, hash, ttlModifier(ttl))
+	return err
+}
+
+// GetRefreshToken looks a refresh token up by the hash of its value, returning
+// sql.ErrNoRows for unknown tokens.
+func (r *SessionDBRepository) GetRefreshToken(ctx context.Context, hash string) (domain.RefreshToken, error) {
+	row := r.QueryRowContext(ctx, `SELECT refresh_tokens.id, refresh_tokens.session_id, sessions.user_id,
+		refresh_tokens.expires_at <= DATETIME('now', 'localtime'), refresh_tokens.used_at IS NOT NULL, sessions.revoked_at IS NOT NULL
+		FROM refresh_tokens JOIN sessions ON sessions.id = refresh_tokens.session_id
+		WHERE refresh_tokens.token_hash = ?`, hash)
+
+	var token domain.RefreshToken
+	err := row.Scan(&token.ID, &token.SessionID, &token.UserID, &token.Expired, &token.Used, &token.SessionRevoked)
+	return token, err
+}
+
+// RotateRefreshToken marks the token id as used, adds its replacement to the
+// session and records the session as seen. It returns sql.ErrNoRows when the
+// token was used in the meantime, so two concurrent refreshes can't both succeed.
+func (r *SessionDBRepository) RotateRefreshToken(ctx context.Context, id int64, sessionID int64, hash string, ttl time.Duration) error {
+	tx, err := r.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET used_at = DATETIME('now', 'localtime') WHERE id = ? AND used_at IS NULL", id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		tx.Rollback()
+		if err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO refresh_tokens (session_id, token_hash, expires_at) VALUES (?, ?, DATETIME('now', 'localtime', ?))", sessionID, hash, ttlModifier(ttl)); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE sessions SET last_seen_at = DATETIME('now', 'localtime') WHERE id = ?", sessionID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ttlModifier formats ttl as a DATETIME() modifier.
+func ttlModifier(ttl time.Duration) string {
+	return fmt.Sprintf("+%d seconds", int64(ttl/time.Second))
+}
+
+// migrateRefreshTokens moves refresh tokens issued before sessions were
+// tracked, which belong to a token family, onto sessions: every family
+// becomes a session and token_families is dropped.
+func migrateRefreshTokens(ctx context.Context, db *sql.DB) error {
+	row := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info('refresh_tokens') WHERE name = 'family_id'")
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, query := range []string{
+		`CREATE TABLE refresh_tokens_sessions
+		(
+			id         integer primary key autoincrement,
+			session_id integer references sessions(id),
+			token_hash varchar(64) unique,
+			expires_at text NOT NULL,
+			used_at    text
+		)`,
+		// Families keep their IDs. One whose ID a session already took, which
+		// only happens if the server ran against the old table, loses its
+		// tokens and its user logs in again
+		`INSERT INTO sessions (id, user_id, user_agent, ip, created_at, last_seen_at, revoked_at)
+			SELECT id, user_id, '', '', created_at, created_at, revoked_at FROM token_families
+			WHERE id NOT IN (SELECT id FROM sessions)`,
+		`INSERT INTO refresh_tokens_sessions (id, session_id, token_hash, expires_at, used_at)
+			SELECT refresh_tokens.id, refresh_tokens.family_id, refresh_tokens.token_hash, refresh_tokens.expires_at, refresh_tokens.used_at
+			FROM refresh_tokens JOIN token_families ON token_families.id = refresh_tokens.family_id
+			JOIN sessions ON sessions.id = token_families.id AND sessions.user_id IS token_families.user_id AND sessions.created_at = token_families.created_at`,
+		"DROP TABLE refresh_tokens",
+		"ALTER TABLE refresh_tokens_sessions RENAME TO refresh_tokens",
+		"DROP TABLE token_families",
+	} {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
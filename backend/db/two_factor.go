@@ -0,0 +1,222 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+type TwoFactorRepository interface {
+	GetTOTPSecret(ctx context.Context, userID int64) (domain.TOTPSecret, error)
+	SetTOTPSecret(ctx context.Context, userID int64, secret string) error
+	ConfirmTOTPSecret(ctx context.Context, userID int64, step int64, codeHashes []string) error
+	UseTOTPStep(ctx context.Context, userID int64, step int64) error
+	AddTwoFactorAttempt(ctx context.Context, userID int64, maxFailures int, lockout time.Duration) error
+	ResetTwoFactorFailures(ctx context.Context, userID int64) error
+	DeleteTOTPSecret(ctx context.Context, userID int64) error
+	SetRecoveryCodes(ctx context.Context, userID int64, codeHashes []string) error
+	UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error
+	CountRecoveryCodes(ctx context.Context, userID int64) (int, error)
+	AddChallenge(ctx context.Context, userID int64, hash string, ttl time.Duration) error
+	GetChallenge(ctx context.Context, hash string) (domain.TwoFactorChallenge, error)
+	AddChallengeAttempt(ctx context.Context, id int64, maxAttempts int) error
+	UseChallenge(ctx context.Context, id int64) error
+}
+
+type TwoFactorDBRepository struct {
+	*sql.DB
+}
+
+func NewTwoFactorRepository(db *sql.DB) TwoFactorRepository {
+	return &TwoFactorDBRepository{DB: db}
+}
+
+func (r *TwoFactorDBRepository) GetTOTPSecret(ctx context.Context, userID int64) (domain.TOTPSecret, error) {
+	row := r.QueryRowContext(ctx, "SELECT user_id, secret, confirmed_at IS NOT NULL, last_step FROM totp_secrets WHERE user_id = ?", userID)
+
+	var secret domain.TOTPSecret
+	return secret, row.Scan(&secret.UserID, &secret.Secret, &secret.Confirmed, &secret.LastStep)
+}
+
+// SetTOTPSecret stores a new, unconfirmed secret, replacing one whose
+// enrollment was never finished.
+func (r *TwoFactorDBRepository) SetTOTPSecret(ctx context.Context, userID int64, secret string) error {
+	_, err := r.ExecContext(ctx, "INSERT OR REPLACE INTO totp_secrets (user_id, secret) VALUES (?, ?)", userID, secret)
+	return err
+}
+
+// ConfirmTOTPSecret turns two-factor authentication on with the first code
+// used and the hashes of fresh recovery codes.
+func (r *TwoFactorDBRepository) ConfirmTOTPSecret(ctx context.Context, userID int64, step int64, codeHashes []string) error {
+	tx, err := r.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE totp_secrets SET confirmed_at = DATETIME('now', 'localtime'), last_step = ? WHERE user_id = ?", step, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := setRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records that the code of step was used. It returns
+// sql.ErrNoRows when a code of that step or a later one was already used.
+func (r *TwoFactorDBRepository) UseTOTPStep(ctx context.Context, userID int64, step int64) error {
+	res, err := r.ExecContext(ctx, "UPDATE totp_secrets SET last_step = ? WHERE user_id = ? AND last_step < ?", step, userID, step)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AddTwoFactorAttempt counts a code about to be checked as a failure until
+// ResetTwoFactorFailures says otherwise. It returns sql.ErrNoRows, counting
+// nothing, once maxFailures codes failed in a row and the last one less than
+// lockout ago. Counting before checking keeps concurrent guesses within the
+// limit too.
+func (r *TwoFactorDBRepository) AddTwoFactorAttempt(ctx context.Context, userID int64, maxFailures int, lockout time.Duration) error {
+	expired := fmt.Sprintf("-%d seconds", int64(lockout/time.Second))
+	res, err := r.ExecContext(ctx, `UPDATE totp_secrets
+		SET failures = CASE WHEN last_failed_at <= DATETIME('now', 'localtime', ?) THEN 1 ELSE failures + 1 END, last_failed_at = DATETIME('now', 'localtime')
+		WHERE user_id = ? AND (failures < ? OR last_failed_at <= DATETIME('now', 'localtime', ?))`, expired, userID, maxFailures, expired)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ResetTwoFactorFailures records that a right code was given.
+func (r *TwoFactorDBRepository) ResetTwoFactorFailures(ctx context.Context, userID int64) error {
+	_, err := r.ExecContext(ctx, "UPDATE totp_secrets SET failures = 0 WHERE user_id = ?", userID)
+	return err
+}
+
+// DeleteTOTPSecret turns two-factor authentication off, dropping the recovery codes too.
+func (r *TwoFactorDBRepository) DeleteTOTPSecret(ctx context.Context, userID int64) error {
+	tx, err := r.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM totp_secrets WHERE user_id = ?", userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetRecoveryCodes replaces the recovery codes of a user.
+func (r *TwoFactorDBRepository) SetRecoveryCodes(ctx context.Context, userID int64, codeHashes []string) error {
+	tx, err := r.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return err
+	}
+
+	if err := setRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode uses up a recovery code, returning sql.ErrNoRows if the user
+// has no such unused code.
+func (r *TwoFactorDBRepository) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error {
+	res, err := r.ExecContext(ctx, "UPDATE recovery_codes SET used_at = DATETIME('now', 'localtime') WHERE user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *TwoFactorDBRepository) CountRecoveryCodes(ctx context.Context, userID int64) (int, error) {
+	var n int
+	err := r.QueryRowContext(ctx, "SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&n)
+	return n, err
+}
+
+func (r *TwoFactorDBRepository) AddChallenge(ctx context.Context, userID int64, hash string, ttl time.Duration) error {
+	_, err := r.ExecContext(ctx, "INSERT INTO two_factor_challenges (user_id, token_hash, expires_at) VALUES (?, ?, DATETIME('now', 'localtime', ?))", userID, hash, ttlModifier(ttl))
+	return err
+}
+
+// GetChallenge looks up an unused, unexpired challenge by the hash of its
+// token, returning sql.ErrNoRows otherwise.
+func (r *TwoFactorDBRepository) GetChallenge(ctx context.Context, hash string) (domain.TwoFactorChallenge, error) {
+	row := r.QueryRowContext(ctx, "SELECT id, user_id, attempts FROM two_factor_challenges WHERE token_hash = ? AND used_at IS NULL AND expires_at > DATETIME('now', 'localtime')", hash)
+
+	var challenge domain.TwoFactorChallenge
+	return challenge, row.Scan(&challenge.ID, &challenge.UserID, &challenge.Attempts)
+}
+
+// AddChallengeAttempt counts a code given with a challenge before it is
+// checked. It returns sql.ErrNoRows once maxAttempts codes were given.
+func (r *TwoFactorDBRepository) AddChallengeAttempt(ctx context.Context, id int64, maxAttempts int) error {
+	res, err := r.ExecContext(ctx, "UPDATE two_factor_challenges SET attempts = attempts + 1 WHERE id = ? AND attempts < ?", id, maxAttempts)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// UseChallenge uses up a challenge, returning sql.ErrNoRows if it was used in
+// the meantime.
+func (r *TwoFactorDBRepository) UseChallenge(ctx context.Context, id int64) error {
+	res, err := r.ExecContext(ctx, "UPDATE two_factor_challenges SET used_at = DATETIME('now', 'localtime') WHERE id = ? AND used_at IS NULL", id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func setRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int64, codeHashes []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -46,6 +46,11 @@ func Initialize(ctx context.Context, db *sql.DB) error {
 		}
 	}
 
+	// The seed data has items on sale but no listing times
+	if err = backfillListedAt(ctx, db); err != nil {
+		return errors.Wrap(err, "Failed to backfill listed_at")
+	}
+
 	return nil
 }
 
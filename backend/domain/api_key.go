@@ -0,0 +1,43 @@
+package domain
+
+// APIKeyScope is something an API key may be used for.
+type APIKeyScope string
+
+const (
+	ScopeItemsRead  APIKeyScope = "items:read"
+	ScopeItemsWrite APIKeyScope = "items:write"
+	ScopeSell       APIKeyScope = "sell"
+	ScopePurchase   APIKeyScope = "purchase"
+)
+
+// Valid reports whether s is a known scope.
+func (s APIKeyScope) Valid() bool {
+	switch s {
+	case ScopeItemsRead, ScopeItemsWrite, ScopeSell, ScopePurchase:
+		return true
+	}
+	return false
+}
+
+// APIKey is a long-lived credential a user creates for scripts. Only the hash
+// of the key is stored; Prefix is its start, shown to tell keys apart.
+type APIKey struct {
+	ID         int64
+	UserID     int64
+	Name       string
+	Prefix     string
+	Scopes     []APIKeyScope
+	ExpiresAt  string
+	LastUsedAt string
+	CreatedAt  string
+}
+
+// Allows reports whether the key grants scope.
+func (k APIKey) Allows(scope APIKeyScope) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package domain
+
+import "strconv"
+
+type AttributeType string
+
+const (
+	AttributeTypeString  AttributeType = "string"
+	AttributeTypeNumber  AttributeType = "number"
+	AttributeTypeBoolean AttributeType = "boolean"
+)
+
+// CategoryAttribute describes a field items of a category and of all its
+// subcategories can carry, such as the size of clothes. When AllowedValues
+// is not empty, values must be one of them.
+type CategoryAttribute struct {
+	ID            int64
+	CategoryID    int64
+	Name          string
+	Type          AttributeType
+	AllowedValues []string
+	Required      bool
+}
+
+// Allows reports whether value, already normalized, is one of the allowed values.
+func (a CategoryAttribute) Allows(value string) bool {
+	if len(a.AllowedValues) == 0 {
+		return true
+	}
+	for _, v := range a.AllowedValues {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeAttributeValue checks that value has the given type and returns
+// its canonical form, so that filters match however the value was written.
+func NormalizeAttributeValue(typ AttributeType, value string) (string, error) {
+	switch typ {
+	case AttributeTypeNumber:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatFloat(n, 'f', -1, 64), nil
+	case AttributeTypeBoolean:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatBool(b), nil
+	default:
+		return value, nil
+	}
+}
@@ -0,0 +1,22 @@
+package domain
+
+// SimilarItem is an item whose primary image looks like another image,
+// Distance being the number of differing perceptual hash bits.
+type SimilarItem struct {
+	ItemID   int32
+	SellerID int64
+	Status   ItemStatus
+	Distance int
+}
+
+// DuplicateFlag records that an item was listed with an image similar to the
+// one of an earlier item, possibly from another seller.
+type DuplicateFlag struct {
+	ID                  int64
+	ItemID              int32
+	SellerID            int64
+	DuplicateOfID       int32
+	DuplicateOfSellerID int64
+	Distance            int
+	CreatedAt           string
+}
@@ -0,0 +1,17 @@
+package domain
+
+// Follow is a user following a seller. With Notify set, the follower is
+// notified when the seller puts an item on sale.
+type Follow struct {
+	FollowerID int64
+	FolloweeID int64
+	// FolloweeName is the name of the followed user, filled when listing follows.
+	FolloweeName string
+	Notify       bool
+	CreatedAt    string
+}
+
+type FollowCounts struct {
+	Followers int
+	Following int
+}
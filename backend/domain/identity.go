@@ -0,0 +1,22 @@
+package domain
+
+// Identity links an account at an external OpenID Connect provider, named by
+// its issuer and subject, to a user.
+type Identity struct {
+	ID        int64
+	UserID    int64
+	Issuer    string
+	Subject   string
+	Email     string
+	CreatedAt string
+}
+
+// OIDCState is a pending redirect to the provider. Its state token comes back
+// with the authorization code, and UserID is set when a logged-in user links
+// an identity rather than logs in.
+type OIDCState struct {
+	ID           int64
+	Nonce        string
+	CodeVerifier string
+	UserID       int64
+}
@@ -1,5 +1,7 @@
 package domain
 
+import "strings"
+
 type ItemStatus int
 
 const (
@@ -8,6 +10,9 @@ const (
 	ItemStatusSoldOut
 )
 
+// Item is a listing. Image carries uploaded image data on writes only;
+// reads return ImageHash, the blob store key of the primary image, and
+// Placeholder, the colour to show while that image loads.
 type Item struct {
 	ID          int32
 	Name        string
@@ -16,12 +21,77 @@ type Item struct {
 	CategoryID  int64
 	UserID      int64
 	Image       []byte
+	ImageHash   string
+	Placeholder string
 	Status      ItemStatus
 	CreatedAt   string
 	UpdatedAt   string
+	// ListedAt is when the item was first put on sale, empty until then.
+	ListedAt string
 }
 
+// Category is a node of the category tree; ParentID is 0 for top level categories.
 type Category struct {
-	ID   int64
-	Name string
+	ID       int64
+	Name     string
+	ParentID int64
+	// UpdatedAt is when the category was last renamed or moved, empty for
+	// categories untouched since the column was added.
+	UpdatedAt string
+}
+
+// CleanCategoryName trims name and collapses runs of whitespace into single spaces.
+func CleanCategoryName(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// SameCategoryName reports whether two category names only differ in case or whitespace.
+func SameCategoryName(a string, b string) bool {
+	return strings.EqualFold(CleanCategoryName(a), CleanCategoryName(b))
+}
+
+// PriceHistory is a single price an item was listed at, starting at CreatedAt.
+type PriceHistory struct {
+	ID        int64
+	ItemID    int32
+	Price     int64
+	ChangedBy int64
+	CreatedAt string
+}
+
+// PriceWatch asks for a notification once the item's price drops to Threshold or below.
+type PriceWatch struct {
+	UserID    int64
+	ItemID    int32
+	Threshold int64
+}
+
+// ItemRevision is a snapshot of an item as it was before an edit, along with
+// what that edit changed. Reverting to a revision restores the snapshot.
+type ItemRevision struct {
+	ID          int64
+	ItemID      int32
+	EditorID    int64
+	Name        string
+	Price       int64
+	Description string
+	CategoryID  int64
+	ImageHash   string
+	Changes     []FieldChange
+	CreatedAt   string
 }
+
+type FieldChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// ImageSize names one of the variants every uploaded image is stored in.
+type ImageSize string
+
+const (
+	ImageSizeThumb  ImageSize = "thumb"
+	ImageSizeMedium ImageSize = "medium"
+	ImageSizeFull   ImageSize = "full"
+)
@@ -0,0 +1,16 @@
+package domain
+
+// LoginAttempt records a login, UserID being 0 when Login named no account.
+type LoginAttempt struct {
+	UserID  int64
+	Login   string
+	IP      string
+	Success bool
+}
+
+// LoginFailures counts recent failed logins, LastAt being the time of the
+// latest one.
+type LoginFailures struct {
+	Count  int
+	LastAt string
+}
@@ -0,0 +1,18 @@
+package domain
+
+type NotificationType int
+
+const (
+	NotificationTypePriceDrop NotificationType = iota + 1
+	NotificationTypeNewListing
+)
+
+type Notification struct {
+	ID        int64
+	UserID    int64
+	Type      NotificationType
+	ItemID    int32
+	Message   string
+	IsRead    bool
+	CreatedAt string
+}
@@ -0,0 +1,10 @@
+package domain
+
+type Purchase struct {
+	ID        int64
+	ItemID    int32
+	BuyerID   int64
+	SellerID  int64
+	Price     int64
+	CreatedAt string
+}
@@ -0,0 +1,35 @@
+package domain
+
+// ReviewerRole is the side of a purchase a reviewer was on.
+type ReviewerRole string
+
+const (
+	ReviewerBuyer  ReviewerRole = "buyer"
+	ReviewerSeller ReviewerRole = "seller"
+)
+
+const (
+	MinRating = 1
+	MaxRating = 5
+)
+
+// Review is a rating with a comment left by one side of a purchase about the
+// other. Each side reviews a purchase at most once.
+type Review struct {
+	ID           int64
+	PurchaseID   int64
+	ItemID       int32
+	ReviewerID   int64
+	ReviewerName string
+	RevieweeID   int64
+	Role         ReviewerRole
+	Rating       int
+	Comment      string
+	CreatedAt    string
+}
+
+// RatingSummary aggregates the reviews a user received.
+type RatingSummary struct {
+	Average float64
+	Count   int
+}
@@ -0,0 +1,24 @@
+package domain
+
+// Session is a login on one device. It lasts as long as its refresh tokens
+// keep being exchanged, and revoking it logs the device out.
+type Session struct {
+	ID         int64
+	UserID     int64
+	UserAgent  string
+	IP         string
+	CreatedAt  string
+	LastSeenAt string
+}
+
+// RefreshToken is a single-use token exchanged for a new access token. Each
+// refresh replaces the token with a new one of the same session, so a token
+// presented twice reveals that it leaked.
+type RefreshToken struct {
+	ID             int64
+	SessionID      int64
+	UserID         int64
+	Expired        bool
+	Used           bool
+	SessionRevoked bool
+}
@@ -0,0 +1,19 @@
+package domain
+
+// TOTPSecret is the authenticator app secret of a user. Two-factor
+// authentication is on once the user confirmed the secret with a code.
+type TOTPSecret struct {
+	UserID    int64
+	Secret    string
+	Confirmed bool
+	// LastStep is the time step of the last code used, which can't be used again.
+	LastStep int64
+}
+
+// TwoFactorChallenge is handed out by a login whose password was right and
+// exchanged for tokens together with a code.
+type TwoFactorChallenge struct {
+	ID       int64
+	UserID   int64
+	Attempts int
+}
@@ -1,8 +1,49 @@
 package domain
 
+// Role grants access to administrative endpoints. Each role includes the
+// permissions of the roles below it.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// Valid reports whether r is a known role.
+func (r Role) Valid() bool {
+	return roleRanks[r] > 0
+}
+
+// Includes reports whether r grants at least the permissions of required.
+func (r Role) Includes(required Role) bool {
+	return r.Valid() && roleRanks[r] >= roleRanks[required]
+}
+
 type User struct {
 	ID       int64
 	Password string
 	Name     string
 	Balance  int64
+	Role     Role
+	Email    string
+}
+
+// Profile is the public part of a user, shown to everyone. DisplayName
+// defaults to Name when empty, and JoinedAt is empty for users created before
+// join dates were recorded.
+type Profile struct {
+	UserID      int64
+	Name        string
+	DisplayName string
+	Bio         string
+	Location    string
+	AvatarHash  string
+	JoinedAt    string
 }
@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+const (
+	// apiKeyPrefix tells API keys apart from access tokens in the
+	// Authorization header.
+	apiKeyPrefix         = "mk_"
+	apiKeyShownLength    = 10
+	apiKeyContextKey     = "api_key"
+	maxAPIKeys           = 20
+	maxAPIKeyNameLength  = 50
+	defaultAPIKeyTTLDays = 90
+	maxAPIKeyTTLDays     = 365
+)
+
+var errInvalidAPIKey = errors.New("invalid or expired API key")
+
+// apiKeyRouteScopes are the only routes API keys may be used on, by method
+// and route path, with the scope each needs. Account, session and admin
+// endpoints are deliberately missing, so a leaked key can't take over the
+// account.
+var apiKeyRouteScopes = map[string]domain.APIKeyScope{
+	"GET /users/:userID/items":                         domain.ScopeItemsRead,
+	"GET /items/:itemID/revisions":                     domain.ScopeItemsRead,
+	"GET /items/:itemID/revisions/:revisionID/image":   domain.ScopeItemsRead,
+	"GET /me/feed":                                     domain.ScopeItemsRead,
+	"POST /items":                                      domain.ScopeItemsWrite,
+	"PUT /items/:itemID":                               domain.ScopeItemsWrite,
+	"PATCH /items/:itemID":                             domain.ScopeItemsWrite,
+	"POST /items/:itemID/images":                       domain.ScopeItemsWrite,
+	"DELETE /items/:itemID/images/:n":                  domain.ScopeItemsWrite,
+	"PUT /items/:itemID/images/order":                  domain.ScopeItemsWrite,
+	"POST /items/:itemID/revisions/:revisionID/revert": domain.ScopeItemsWrite,
+	"POST /sell":                                       domain.ScopeSell,
+	"GET /balance":                                     domain.ScopePurchase,
+	"POST /purchase/:itemID":                           domain.ScopePurchase,
+	"POST /onsite-purchase/:itemID":                    domain.ScopePurchase,
+	"POST /onsite-purchase/:itemID/available":          domain.ScopePurchase,
+}
+
+type createAPIKeyRequest struct {
+	Name          string               `json:"name"`
+	Scopes        []domain.APIKeyScope `json:"scopes"`
+	ExpiresInDays int                  `json:"expires_in_days"`
+}
+
+type apiKeyResponse struct {
+	ID         int64                `json:"id"`
+	Name       string               `json:"name"`
+	Prefix     string               `json:"prefix"`
+	Scopes     []domain.APIKeyScope `json:"scopes"`
+	ExpiresAt  string               `json:"expires_at"`
+	LastUsedAt string               `json:"last_used_at,omitempty"`
+	CreatedAt  string               `json:"created_at"`
+}
+
+type createAPIKeyResponse struct {
+	apiKeyResponse
+	Key string `json:"key"`
+}
+
+// GetAPIKeys lists the API keys of the current user. The keys themselves are
+// only shown once, when created.
+func (h *Handler) GetAPIKeys(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	keys, err := h.APIKeyRepo.GetAPIKeys(c.Request().Context(), userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	res := make([]apiKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		res = append(res, newAPIKeyResponse(key))
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// CreateAPIKey creates a named API key with scopes that expires after
+// expires_in_days, 90 by default. The key is sent as a bearer token instead
+// of an access token.
+func (h *Handler) CreateAPIKey(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	req := new(createAPIKeyRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxAPIKeyNameLength {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("name must be 1 to %d characters", maxAPIKeyNameLength))
+	}
+	if len(req.Scopes) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "at least one scope is required")
+	}
+	var scopes []domain.APIKeyScope
+	seen := make(map[domain.APIKeyScope]bool)
+	for _, scope := range req.Scopes {
+		if !scope.Valid() {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown scope %q", scope))
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = defaultAPIKeyTTLDays
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxAPIKeyTTLDays {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("expires_in_days must be 1 to %d", maxAPIKeyTTLDays))
+	}
+
+	existing, err := h.APIKeyRepo.GetAPIKeys(ctx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if len(existing) >= maxAPIKeys {
+		return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("at most %d API keys are allowed, revoke one first", maxAPIKeys))
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	secret := apiKeyPrefix + token
+	key := domain.APIKey{
+		UserID: userID,
+		Name:   req.Name,
+		Prefix: secret[:apiKeyShownLength],
+		Scopes: scopes,
+	}
+	ttl := time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	key, err = h.APIKeyRepo.AddAPIKey(ctx, key, hashToken(secret), ttl)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusCreated, createAPIKeyResponse{
+		apiKeyResponse: newAPIKeyResponse(key),
+		Key:            secret,
+	})
+}
+
+// RevokeAPIKey revokes an API key of the current user. It is rejected from
+// the next request on.
+func (h *Handler) RevokeAPIKey(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	keyID, err := strconv.ParseInt(c.Param("keyID"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid keyID")
+	}
+
+	if err := h.APIKeyRepo.RevokeAPIKey(c.Request().Context(), userID, keyID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "API key not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// RestrictAPIKeys lets requests authenticated with an API key through only
+// to the routes of apiKeyRouteScopes their key has the scope for. It must run
+// after the JWT middleware.
+func RestrictAPIKeys(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key, ok := c.Get(apiKeyContextKey).(domain.APIKey)
+		if !ok {
+			return next(c)
+		}
+
+		scope, ok := apiKeyRouteScopes[c.Request().Method+" "+c.Path()]
+		if !ok {
+			return echo.NewHTTPError(http.StatusForbidden, "API keys can't be used for this endpoint")
+		}
+		if !key.Allows(scope) {
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("API key lacks the %s scope", scope))
+		}
+		return next(c)
+	}
+}
+
+// parseAPIKey authenticates a request by API key for ParseToken. The key's
+// user is put in token claims so handlers don't tell keys and tokens apart.
+// The claims grant no role, so keys never reach moderator or admin routes.
+func (h *Handler) parseAPIKey(c echo.Context, auth string) (interface{}, error) {
+	key, err := h.APIKeyRepo.UseAPIKey(c.Request().Context(), hashToken(auth))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errInvalidAPIKey
+		}
+		return nil, err
+	}
+
+	c.Set(apiKeyContextKey, key)
+	return &jwt.Token{
+		Valid:  true,
+		Claims: &JwtCustomClaims{UserID: key.UserID},
+	}, nil
+}
+
+func newAPIKeyResponse(key domain.APIKey) apiKeyResponse {
+	return apiKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.Scopes,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+		CreatedAt:  key.CreatedAt,
+	}
+}
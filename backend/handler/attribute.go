@@ -0,0 +1,279 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+// attributeFilterPrefix marks the query parameters filtering listings by
+// attribute, e.g. ?attr.size=M
+const attributeFilterPrefix = "attr."
+
+type categoryAttributeRequest struct {
+	Name          string               `json:"name"`
+	Type          domain.AttributeType `json:"type"`
+	AllowedValues []string             `json:"allowed_values"`
+	Required      bool                 `json:"required"`
+}
+
+type getCategoryAttributesResponse struct {
+	CategoryID    int64                `json:"category_id"`
+	Name          string               `json:"name"`
+	Type          domain.AttributeType `json:"type"`
+	AllowedValues []string             `json:"allowed_values,omitempty"`
+	Required      bool                 `json:"required"`
+}
+
+// GetCategoryAttributes returns the attributes items of a category can
+// carry, including the ones inherited from its parents.
+func (h *Handler) GetCategoryAttributes(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	categoryID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid category ID")
+	}
+	if _, err := h.ItemRepo.GetCategory(ctx, categoryID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "Category not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	schema, err := h.AttributeRepo.GetAttributeSchema(ctx, categoryID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	res := make([]getCategoryAttributesResponse, len(schema))
+	for i, attr := range schema {
+		res[i] = getCategoryAttributesResponse{
+			CategoryID:    attr.CategoryID,
+			Name:          attr.Name,
+			Type:          attr.Type,
+			AllowedValues: attr.AllowedValues,
+			Required:      attr.Required,
+		}
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+// SetCategoryAttribute defines an attribute of a category, replacing any
+// previous definition with the same name. Existing items are not revalidated.
+func (h *Handler) SetCategoryAttribute(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	categoryID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid category ID")
+	}
+
+	req := new(categoryAttributeRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 50 {
+		return echo.NewHTTPError(http.StatusBadRequest, "name must be between 1 and 50 characters")
+	}
+	switch req.Type {
+	case domain.AttributeTypeString, domain.AttributeTypeNumber, domain.AttributeTypeBoolean:
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "type must be one of string, number or boolean")
+	}
+	for i, value := range req.AllowedValues {
+		normalized, err := domain.NormalizeAttributeValue(req.Type, value)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("allowed value %q is not a %s", value, req.Type))
+		}
+		req.AllowedValues[i] = normalized
+	}
+
+	if _, err := h.ItemRepo.GetCategory(ctx, categoryID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "Category not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	attr, err := h.AttributeRepo.SetCategoryAttribute(ctx, domain.CategoryAttribute{
+		CategoryID:    categoryID,
+		Name:          req.Name,
+		Type:          req.Type,
+		AllowedValues: req.AllowedValues,
+		Required:      req.Required,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, getCategoryAttributesResponse{
+		CategoryID:    attr.CategoryID,
+		Name:          attr.Name,
+		Type:          attr.Type,
+		AllowedValues: attr.AllowedValues,
+		Required:      attr.Required,
+	})
+}
+
+func (h *Handler) DeleteCategoryAttribute(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	categoryID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid category ID")
+	}
+
+	if err := h.AttributeRepo.DeleteCategoryAttribute(ctx, categoryID, c.Param("name")); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "Attribute not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// readAttributes parses the optional "attributes" form field, a JSON object
+// of attribute names to values.
+func readAttributes(c echo.Context) (map[string]string, error) {
+	attrs := make(map[string]string)
+	value := c.FormValue("attributes")
+	if value == "" {
+		return attrs, nil
+	}
+	if err := json.Unmarshal([]byte(value), &attrs); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "attributes must be a JSON object of strings")
+	}
+	return attrs, nil
+}
+
+// validateAttributes checks attrs against the attribute schema of a category
+// and returns them normalized, without empty values. A missing, mistyped,
+// disallowed or undefined value is a 400 naming the attribute.
+func (h *Handler) validateAttributes(ctx context.Context, categoryID int64, attrs map[string]string) (map[string]string, error) {
+	schema, err := h.AttributeRepo.GetAttributeSchema(ctx, categoryID)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	valid := make(map[string]string, len(attrs))
+	for _, attr := range schema {
+		value := strings.TrimSpace(attrs[attr.Name])
+		if value == "" {
+			if attr.Required {
+				return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("attribute %q is required", attr.Name))
+			}
+			continue
+		}
+
+		normalized, err := domain.NormalizeAttributeValue(attr.Type, value)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("attribute %q must be a %s", attr.Name, attr.Type))
+		}
+		if !attr.Allows(normalized) {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("attribute %q must be one of %s", attr.Name, strings.Join(attr.AllowedValues, ", ")))
+		}
+		valid[attr.Name] = normalized
+	}
+
+	for name, value := range attrs {
+		if _, ok := valid[name]; !ok && strings.TrimSpace(value) != "" {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("attribute %q is not defined for this category", name))
+		}
+	}
+
+	return valid, nil
+}
+
+// filterByAttributes keeps the items matching every attr.<name>=<value>
+// query parameter of the request. Values are compared in the canonical form
+// of the attribute's type in each item's category, and a value that doesn't
+// fit the type in any of them is a 400.
+func (h *Handler) filterByAttributes(c echo.Context, items []domain.Item) ([]domain.Item, error) {
+	ctx := c.Request().Context()
+
+	filters := make(map[string]string)
+	for key, values := range c.QueryParams() {
+		if name := strings.TrimPrefix(key, attributeFilterPrefix); name != key && name != "" {
+			filters[name] = strings.TrimSpace(values[0])
+		}
+	}
+	if len(filters) == 0 {
+		return items, nil
+	}
+
+	byCategory := make(map[int64][]domain.Item)
+	for _, item := range items {
+		byCategory[item.CategoryID] = append(byCategory[item.CategoryID], item)
+	}
+
+	// mismatch holds the type a filter value didn't fit, until it fits another category
+	mismatch := make(map[string]domain.AttributeType)
+	fits := make(map[string]bool)
+	matched := make(map[int32]bool)
+	for categoryID, categoryItems := range byCategory {
+		schema, err := h.AttributeRepo.GetAttributeSchema(ctx, categoryID)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+
+		// Names the schema doesn't define can only match values left over
+		// from a deleted definition, which are compared as they are.
+		normalized := make(map[string]string, len(filters))
+		for name, value := range filters {
+			normalized[name] = value
+		}
+		applicable := true
+		for _, attr := range schema {
+			value, ok := filters[attr.Name]
+			if !ok {
+				continue
+			}
+			if normalized[attr.Name], err = domain.NormalizeAttributeValue(attr.Type, value); err != nil {
+				mismatch[attr.Name] = attr.Type
+				applicable = false
+				continue
+			}
+			fits[attr.Name] = true
+		}
+		if !applicable {
+			continue
+		}
+
+		ids, err := h.AttributeRepo.GetItemIDsByAttributes(ctx, normalized)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+		for _, item := range categoryItems {
+			if ids[item.ID] {
+				matched[item.ID] = true
+			}
+		}
+	}
+
+	for name, typ := range mismatch {
+		if !fits[name] {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("attribute %q must be a %s", name, typ))
+		}
+	}
+
+	filtered := make([]domain.Item, 0, len(items))
+	for _, item := range items {
+		if matched[item.ID] {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered, nil
+}
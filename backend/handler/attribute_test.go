@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/db"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+// newAttributeTestHandler returns a handler with a "Phones" category whose
+// items have a number attribute "storage", a "Clothes" category whose items
+// have a string attribute "size", and one item on sale in each.
+func newAttributeTestHandler(t *testing.T) (*Handler, domain.Category, domain.Category) {
+	t.Helper()
+	ctx := context.Background()
+	sqlDB := newTestDB(t)
+	h := &Handler{
+		DB:            sqlDB,
+		ItemRepo:      db.NewItemRepository(sqlDB, nil),
+		AttributeRepo: db.NewAttributeRepository(sqlDB),
+	}
+
+	phones, err := h.ItemRepo.AddCategory(ctx, domain.Category{Name: "Phones"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clothes, err := h.ItemRepo.AddCategory(ctx, domain.Category{Name: "Clothes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, attr := range []domain.CategoryAttribute{
+		{CategoryID: phones.ID, Name: "storage", Type: domain.AttributeTypeNumber},
+		{CategoryID: clothes.ID, Name: "size", Type: domain.AttributeTypeString},
+	} {
+		if _, err := h.AttributeRepo.SetCategoryAttribute(ctx, attr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for id, item := range map[int32]struct {
+		categoryID int64
+		attrs      map[string]string
+	}{
+		1: {phones.ID, map[string]string{"storage": "128"}},
+		2: {clothes.ID, map[string]string{"size": "M"}},
+	} {
+		if _, err := sqlDB.Exec("INSERT INTO items (id, name, price, description, category_id, seller_id, status) VALUES (?, 'item', 100, '', ?, 1, ?)", id, item.categoryID, domain.ItemStatusOnSale); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.AttributeRepo.SetItemAttributes(ctx, id, item.attrs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return h, phones, clothes
+}
+
+// getItemIDs calls a listing handler with the query string query and
+// returns the status and the IDs of the listed items.
+func getItemIDs(t *testing.T, handler echo.HandlerFunc, categoryID int64, query string) (int, []int32) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(strconv.FormatInt(categoryID, 10))
+
+	if err := handler(c); err != nil {
+		var he *echo.HTTPError
+		if !errors.As(err, &he) {
+			t.Fatalf("handler returned %v", err)
+		}
+		return he.Code, nil
+	}
+
+	var res []struct {
+		ID int32 `json:"id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int32, len(res))
+	for i, item := range res {
+		ids[i] = item.ID
+	}
+	return rec.Code, ids
+}
+
+func TestFilterByAttributesNormalizesValues(t *testing.T) {
+	h, phones, _ := newAttributeTestHandler(t)
+
+	for _, value := range []string{"128", "128.0", "1.28e2"} {
+		status, ids := getItemIDs(t, h.GetItemsByCategory, phones.ID, "attr.storage="+value)
+		if status != http.StatusOK || len(ids) != 1 || ids[0] != 1 {
+			t.Errorf("attr.storage=%s: status %d, items %v, want item 1", value, status, ids)
+		}
+	}
+
+	status, ids := getItemIDs(t, h.GetItemsByCategory, phones.ID, "attr.storage=64")
+	if status != http.StatusOK || len(ids) != 0 {
+		t.Errorf("attr.storage=64: status %d, items %v, want none", status, ids)
+	}
+}
+
+func TestFilterByAttributesRejectsMistypedValues(t *testing.T) {
+	h, phones, _ := newAttributeTestHandler(t)
+
+	if status, _ := getItemIDs(t, h.GetItemsByCategory, phones.ID, "attr.storage=lots"); status != http.StatusBadRequest {
+		t.Errorf("attr.storage=lots in Phones: status %d, want 400", status)
+	}
+	// Only Phones defines storage, so the value doesn't fit any listed item
+	if status, _ := getItemIDs(t, h.GetOnSaleItems, 0, "attr.storage=lots"); status != http.StatusBadRequest {
+		t.Errorf("attr.storage=lots on sale: status %d, want 400", status)
+	}
+}
+
+func TestFilterByAttributesAcrossCategories(t *testing.T) {
+	h, _, _ := newAttributeTestHandler(t)
+
+	status, ids := getItemIDs(t, h.GetOnSaleItems, 0, "attr.size=M")
+	if status != http.StatusOK || len(ids) != 1 || ids[0] != 2 {
+		t.Errorf("attr.size=M on sale: status %d, items %v, want item 2", status, ids)
+	}
+}
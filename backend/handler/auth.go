@@ -0,0 +1,250 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+var (
+	accessTokenTTL  = getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute)
+	refreshTokenTTL = getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+)
+
+var errTokenRevoked = errors.New("token has been revoked")
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type logoutAllRequest struct {
+	// RevokeAPIKeys also revokes every API key of the user.
+	RevokeAPIKeys bool `json:"revoke_api_keys"`
+}
+
+type tokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// ParseToken verifies an access token for the JWT middleware and rejects the
+// tokens of revoked sessions, so logging out takes effect before the access
+// token expires. API keys are accepted in place of access tokens.
+func (h *Handler) ParseToken(c echo.Context, auth string) (interface{}, error) {
+	if strings.HasPrefix(auth, apiKeyPrefix) {
+		return h.parseAPIKey(c, auth)
+	}
+
+	token, err := jwt.ParseWithClaims(auth, new(JwtCustomClaims), h.Keys.Keyfunc, jwt.WithValidMethods(h.Keys.Methods()))
+	if err != nil {
+		return nil, err
+	}
+
+	claims := token.Claims.(*JwtCustomClaims)
+	active, err := h.SessionRepo.TouchSession(c.Request().Context(), claims.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, errTokenRevoked
+	}
+	return token, nil
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token. Refresh tokens are single-use: presenting one again means it was
+// stolen, so the whole session is revoked and its owner has to log in again.
+func (h *Handler) Refresh(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	req := new(refreshRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	if req.RefreshToken == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "refresh_token is required")
+	}
+
+	stored, err := h.SessionRepo.GetRefreshToken(ctx, hashToken(req.RefreshToken))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid refresh token")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if stored.SessionRevoked || stored.Expired {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid refresh token")
+	}
+	if stored.Used {
+		return h.revokeReusedSession(ctx, stored)
+	}
+
+	user, err := h.UserRepo.GetUser(ctx, stored.UserID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	refreshToken, err := newToken()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if err := h.SessionRepo.RotateRefreshToken(ctx, stored.ID, stored.SessionID, hashToken(refreshToken), refreshTokenTTL); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return h.revokeReusedSession(ctx, stored)
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	accessToken, err := h.signAccessToken(user, stored.SessionID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, tokenResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(accessTokenTTL / time.Second),
+	})
+}
+
+// Logout revokes the session of a refresh token, ending that login only.
+func (h *Handler) Logout(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	req := new(refreshRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	if req.RefreshToken == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "refresh_token is required")
+	}
+
+	stored, err := h.SessionRepo.GetRefreshToken(ctx, hashToken(req.RefreshToken))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid refresh token")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	if err := h.SessionRepo.RevokeSession(ctx, stored.UserID, stored.SessionID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// LogoutAll revokes every login of the current user, e.g. after losing a
+// phone, and with revoke_api_keys their API keys too.
+func (h *Handler) LogoutAll(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	req := new(logoutAllRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	if err := h.SessionRepo.RevokeUserSessions(ctx, userID, 0); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if req.RevokeAPIKeys {
+		if err := h.APIKeyRepo.RevokeUserAPIKeys(ctx, userID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetJWKS publishes the public keys access tokens are verified with, so other
+// services can verify them.
+func (h *Handler) GetJWKS(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.Keys.JWKS())
+}
+
+// startSession starts a session for a new login from the device of c and
+// returns its first access and refresh tokens.
+func (h *Handler) startSession(c echo.Context, user domain.User) (string, string, error) {
+	ctx := c.Request().Context()
+
+	sessionID, err := h.SessionRepo.AddSession(ctx, domain.Session{
+		UserID:    user.ID,
+		UserAgent: c.Request().UserAgent(),
+		IP:        c.RealIP(),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, err := newToken()
+	if err != nil {
+		return "", "", err
+	}
+	if err := h.SessionRepo.AddRefreshToken(ctx, sessionID, hashToken(refreshToken), refreshTokenTTL); err != nil {
+		return "", "", err
+	}
+
+	accessToken, err := h.signAccessToken(user, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, refreshToken, nil
+}
+
+func (h *Handler) revokeReusedSession(ctx context.Context, stored domain.RefreshToken) error {
+	if err := h.SessionRepo.RevokeSession(ctx, stored.UserID, stored.SessionID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return echo.NewHTTPError(http.StatusUnauthorized, "Refresh token reused, please log in again")
+}
+
+func (h *Handler) signAccessToken(user domain.User, sessionID int64) (string, error) {
+	now := time.Now()
+	claims := &JwtCustomClaims{
+		UserID:    user.ID,
+		Role:      user.Role,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenTTL)),
+		},
+	}
+	return h.Keys.Sign(claims)
+}
+
+// newToken returns a random opaque token. Only its hash is stored.
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(getEnv(key, ""))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
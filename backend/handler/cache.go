@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/blob"
+)
+
+const (
+	// An item's image URL shows a new image once the item is edited, so
+	// images are only reused for a short while without revalidating.
+	imageCacheControl = "public, max-age=60"
+	// JSON responses are revalidated every time, which is cheap with an ETag.
+	jsonCacheControl = "public, no-cache"
+)
+
+const (
+	headerETag        = "ETag"
+	headerIfNoneMatch = "If-None-Match"
+)
+
+// dbTimeLayout is the format of timestamps written by SQLite's DATETIME().
+const dbTimeLayout = "2006-01-02 15:04:05"
+
+// serveImage sends the image stored under key. Keys are content hashes, so
+// they double as strong ETags and a matching request is answered with 304
+// without reading the image at all.
+func (h *Handler) serveImage(c echo.Context, key string) error {
+	if setCacheHeaders(c, `"`+key+`"`, time.Time{}, imageCacheControl) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	data, err := h.ItemRepo.GetImage(c.Request().Context(), key)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "Image not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "image/") {
+		return echo.NewHTTPError(http.StatusNotFound, "uploaded file is not an image")
+	}
+	return c.Blob(http.StatusOK, contentType, data)
+}
+
+// cachedJSON sends v with an ETag hashed from the encoded body, answering 304
+// when the client's copy is still current. lastModified must cover everything
+// v is built from, category names included; a zero lastModified omits
+// Last-Modified.
+func cachedJSON(c echo.Context, lastModified time.Time, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	if setCacheHeaders(c, `"`+blob.Key(body)+`"`, lastModified, jsonCacheControl) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.JSONBlob(http.StatusOK, body)
+}
+
+// setCacheHeaders sets the validators of a response and reports whether the
+// request's conditional headers match them. If-None-Match takes precedence
+// over If-Modified-Since.
+func setCacheHeaders(c echo.Context, etag string, lastModified time.Time, cacheControl string) bool {
+	header := c.Response().Header()
+	header.Set(headerETag, etag)
+	header.Set(echo.HeaderCacheControl, cacheControl)
+	if !lastModified.IsZero() {
+		header.Set(echo.HeaderLastModified, lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	req := c.Request()
+	if ifNoneMatch := req.Header.Get(headerIfNoneMatch); ifNoneMatch != "" {
+		return etagMatches(ifNoneMatch, etag)
+	}
+	if lastModified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(req.Header.Get(echo.HeaderIfModifiedSince))
+	return err == nil && !lastModified.Truncate(time.Second).After(since)
+}
+
+// etagMatches compares an If-None-Match header with etag using the weak
+// comparison required for GET requests.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// latestDBTime returns the latest of the SQLite timestamps values, ignoring
+// those that can't be parsed.
+func latestDBTime(values ...string) time.Time {
+	var latest time.Time
+	for _, value := range values {
+		if t := parseDBTime(value); t.After(latest) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// parseDBTime reads a timestamp written by SQLite, returning the zero time
+// when it can't be parsed.
+func parseDBTime(value string) time.Time {
+	t, err := time.ParseInLocation(dbTimeLayout, value, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
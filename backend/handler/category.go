@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/db"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+type getCategoryTreeResponse struct {
+	ID       int64                     `json:"id"`
+	Name     string                    `json:"name"`
+	Children []getCategoryTreeResponse `json:"children"`
+}
+
+type setCategoryParentRequest struct {
+	ParentID int64 `json:"parent_id"`
+}
+
+type renameCategoryRequest struct {
+	Name string `json:"name"`
+}
+
+type mergeCategoryRequest struct {
+	IntoID int64 `json:"into_id"`
+}
+
+type mergeCategoryResponse struct {
+	ID         int64 `json:"id"`
+	MovedItems int64 `json:"moved_items"`
+	// DroppedAttributes counts the item attribute values the target
+	// category's schema doesn't accept.
+	DroppedAttributes int64 `json:"dropped_attributes"`
+}
+
+// GetCategoryTree returns every category nested below its parent.
+func (h *Handler) GetCategoryTree(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	cats, err := h.ItemRepo.GetCategories(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	children := make(map[int64][]domain.Category)
+	for _, cat := range cats {
+		children[cat.ParentID] = append(children[cat.ParentID], cat)
+	}
+
+	var build func(parentID int64) []getCategoryTreeResponse
+	build = func(parentID int64) []getCategoryTreeResponse {
+		res := make([]getCategoryTreeResponse, 0, len(children[parentID]))
+		for _, cat := range children[parentID] {
+			res = append(res, getCategoryTreeResponse{ID: cat.ID, Name: cat.Name, Children: build(cat.ID)})
+		}
+		return res
+	}
+
+	return cachedJSON(c, time.Time{}, build(0))
+}
+
+// SetCategoryParent moves a category below another one, or to the top level
+// with a parent_id of 0. Only admins may restructure the tree.
+func (h *Handler) SetCategoryParent(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	categoryID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid category ID")
+	}
+
+	req := new(setCategoryParentRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	if req.ParentID != 0 {
+		if _, err := h.ItemRepo.GetCategory(ctx, req.ParentID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return echo.NewHTTPError(http.StatusBadRequest, "Parent category does not exist")
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+	}
+
+	category, err := h.ItemRepo.GetCategory(ctx, categoryID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "Category not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	existing, err := h.ItemRepo.GetCategoryByName(ctx, req.ParentID, category.Name)
+	if err == nil && existing.ID != category.ID {
+		return echo.NewHTTPError(http.StatusBadRequest, "Category already exists")
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	if err := h.ItemRepo.SetCategoryParent(ctx, categoryID, req.ParentID); err != nil {
+		if errors.Is(err, db.ErrCategoryCycle) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "Category not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, getCategoriesResponse{ID: category.ID, Name: category.Name, ParentID: req.ParentID})
+}
+
+// RenameCategory renames a category, refusing names already used by one of
+// its siblings. Only admins may rename categories.
+func (h *Handler) RenameCategory(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	categoryID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid category ID")
+	}
+
+	req := new(renameCategoryRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	req.Name = domain.CleanCategoryName(req.Name)
+	if req.Name == "" || len(req.Name) > 50 {
+		return echo.NewHTTPError(http.StatusBadRequest, "name must be between 1 and 50 characters")
+	}
+
+	category, err := h.ItemRepo.GetCategory(ctx, categoryID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "Category not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	// Changing only the case or spacing of its own name is fine
+	existing, err := h.ItemRepo.GetCategoryByName(ctx, category.ParentID, req.Name)
+	if err == nil && existing.ID != category.ID {
+		return echo.NewHTTPError(http.StatusBadRequest, "Category already exists")
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	if err := h.ItemRepo.RenameCategory(ctx, categoryID, req.Name); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, getCategoriesResponse{ID: category.ID, Name: req.Name, ParentID: category.ParentID})
+}
+
+// MergeCategory moves every item and subcategory of a category into another
+// one and deletes it. Attribute values the target category doesn't accept
+// are dropped from the moved items. Only admins may merge categories.
+func (h *Handler) MergeCategory(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	categoryID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid category ID")
+	}
+
+	req := new(mergeCategoryRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	if _, err := h.ItemRepo.GetCategory(ctx, req.IntoID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Target category does not exist")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	moved, dropped, err := h.ItemRepo.MergeCategory(ctx, categoryID, req.IntoID)
+	if err != nil {
+		if errors.Is(err, db.ErrCategoryCycle) {
+			return echo.NewHTTPError(http.StatusBadRequest, "category cannot be merged into itself or one of its subcategories")
+		}
+		if errors.Is(err, db.ErrCategoryExists) {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "Category not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, mergeCategoryResponse{ID: req.IntoID, MovedItems: moved, DroppedAttributes: dropped})
+}
+
+// DeleteCategory deletes a category without items or subcategories. Only
+// admins may delete categories.
+func (h *Handler) DeleteCategory(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	categoryID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid category ID")
+	}
+
+	if err := h.ItemRepo.DeleteCategory(ctx, categoryID); err != nil {
+		if errors.Is(err, db.ErrCategoryNotEmpty) {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "Category not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
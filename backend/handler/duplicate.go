@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+var (
+	// maxDuplicateDistance is how many of the 64 perceptual hash bits may
+	// differ for two images to count as the same photo.
+	maxDuplicateDistance = getEnvInt("DUPLICATE_MAX_DISTANCE", 8)
+	// blockSoldImageReposts rejects new listings whose image looks like the one of a sold item.
+	blockSoldImageReposts = getEnv("BLOCK_SOLD_IMAGE_REPOSTS", "false") == "true"
+)
+
+const reasonSoldItemImage = "sold_item_image"
+
+type getDuplicateFlagsResponse struct {
+	ID                  int64  `json:"id"`
+	ItemID              int32  `json:"item_id"`
+	SellerID            int64  `json:"seller_id"`
+	DuplicateOfID       int32  `json:"duplicate_of_id"`
+	DuplicateOfSellerID int64  `json:"duplicate_of_seller_id"`
+	SameSeller          bool   `json:"same_seller"`
+	Distance            int    `json:"distance"`
+	CreatedAt           string `json:"created_at"`
+}
+
+// GetDuplicateFlags lists the items flagged as likely duplicates, for admins only.
+func (h *Handler) GetDuplicateFlags(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	flags, err := h.DuplicateRepo.GetDuplicateFlags(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	res := make([]getDuplicateFlagsResponse, len(flags))
+	for i, f := range flags {
+		res[i] = getDuplicateFlagsResponse{
+			ID:                  f.ID,
+			ItemID:              f.ItemID,
+			SellerID:            f.SellerID,
+			DuplicateOfID:       f.DuplicateOfID,
+			DuplicateOfSellerID: f.DuplicateOfSellerID,
+			SameSeller:          f.SellerID == f.DuplicateOfSellerID,
+			Distance:            f.Distance,
+			CreatedAt:           f.CreatedAt,
+		}
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+// findDuplicates returns the items whose image looks like the stored image
+// under hash. With blockSoldImageReposts, an image of a sold item is refused
+// with a 409 and reasonSoldItemImage.
+func (h *Handler) findDuplicates(ctx context.Context, hash string) ([]domain.SimilarItem, error) {
+	similar, err := h.DuplicateRepo.GetSimilarItems(ctx, hash, maxDuplicateDistance)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	if blockSoldImageReposts {
+		for _, s := range similar {
+			if s.Status == domain.ItemStatusSoldOut {
+				return nil, echo.NewHTTPError(http.StatusConflict, uploadErrorResponse{Reason: reasonSoldItemImage, Message: "image belongs to an item that has already been sold"})
+			}
+		}
+	}
+
+	return similar, nil
+}
+
+// checkNewImages runs findDuplicates on the images in hashes that an item
+// with the images old didn't have yet, and on the primary image if it
+// changes, so a reused photo can't slip in through any of the image
+// endpoints. The similar items are to be flagged with flagDuplicates once the
+// images are saved.
+func (h *Handler) checkNewImages(ctx context.Context, old []string, hashes []string) ([]domain.SimilarItem, error) {
+	known := make(map[string]bool, len(old))
+	for _, hash := range old {
+		known[hash] = true
+	}
+
+	var similar []domain.SimilarItem
+	seen := make(map[int32]int)
+	for i, hash := range hashes {
+		isNewPrimary := i == 0 && (len(old) == 0 || old[0] != hash)
+		if known[hash] && !isNewPrimary {
+			continue
+		}
+		known[hash] = true
+
+		found, err := h.findDuplicates(ctx, hash)
+		if err != nil {
+			return nil, err
+		}
+		// Keep one entry per item, the closest match
+		for _, s := range found {
+			if j, ok := seen[s.ItemID]; ok {
+				if s.Distance < similar[j].Distance {
+					similar[j] = s
+				}
+				continue
+			}
+			seen[s.ItemID] = len(similar)
+			similar = append(similar, s)
+		}
+	}
+	return similar, nil
+}
+
+// flagDuplicates records the similar items found by checkNewImages for itemID.
+func (h *Handler) flagDuplicates(ctx context.Context, itemID int32, similar []domain.SimilarItem) error {
+	if len(similar) == 0 {
+		return nil
+	}
+	if err := h.DuplicateRepo.AddDuplicateFlags(ctx, itemID, similar); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return nil
+}
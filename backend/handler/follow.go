@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+const (
+	defaultFeedLimit = 20
+	maxFeedLimit     = 100
+)
+
+type followRequest struct {
+	// Notify defaults to true when left out.
+	Notify *bool `json:"notify"`
+}
+
+type followResponse struct {
+	UserID    int64  `json:"user_id"`
+	Name      string `json:"name"`
+	Notify    bool   `json:"notify"`
+	CreatedAt string `json:"created_at"`
+}
+
+type feedItemResponse struct {
+	ID           int32  `json:"id"`
+	Name         string `json:"name"`
+	Price        int64  `json:"price"`
+	CategoryName string `json:"category_name"`
+	Placeholder  string `json:"placeholder,omitempty"`
+	SellerID     int64  `json:"seller_id"`
+	ListedAt     string `json:"listed_at"`
+}
+
+type feedResponse struct {
+	Items []feedItemResponse `json:"items"`
+	// NextCursor is passed as the cursor parameter for the next page. It is
+	// left out on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Follow follows the user in the path. With notify, the default, the current
+// user is notified whenever that user puts an item on sale. Following again
+// only changes notify.
+func (h *Handler) Follow(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	req := new(followRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	notify := req.Notify == nil || *req.Notify
+
+	profile, err := h.getProfile(c)
+	if err != nil {
+		return err
+	}
+	if profile.UserID == userID {
+		return echo.NewHTTPError(http.StatusBadRequest, "You cannot follow yourself.")
+	}
+
+	if err := h.FollowRepo.SetFollow(c.Request().Context(), domain.Follow{
+		FollowerID: userID,
+		FolloweeID: profile.UserID,
+		Notify:     notify,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, "successful")
+}
+
+func (h *Handler) Unfollow(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	followeeID, err := strconv.ParseInt(c.Param("userID"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid userID")
+	}
+
+	if err := h.FollowRepo.DeleteFollow(c.Request().Context(), userID, followeeID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "You are not following this user")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetFollowing lists the users the current user follows.
+func (h *Handler) GetFollowing(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	follows, err := h.FollowRepo.GetFollowing(c.Request().Context(), userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	res := make([]followResponse, 0, len(follows))
+	for _, f := range follows {
+		res = append(res, followResponse{
+			UserID:    f.FolloweeID,
+			Name:      f.FolloweeName,
+			Notify:    f.Notify,
+			CreatedAt: f.CreatedAt,
+		})
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// GetFeed lists the items on sale by the users the current user follows,
+// most recently listed first. It returns limit items, 20 by default, and the
+// cursor of the next page.
+func (h *Handler) GetFeed(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	limit := defaultFeedLimit
+	if s := c.QueryParam("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > maxFeedLimit {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("limit must be 1 to %d", maxFeedLimit))
+		}
+	}
+	var listedAt string
+	var itemID int32
+	if s := c.QueryParam("cursor"); s != "" {
+		if listedAt, itemID, err = decodeFeedCursor(s); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid cursor")
+		}
+	}
+
+	// One more item than asked for tells whether there is a next page
+	items, err := h.FollowRepo.GetFeed(ctx, userID, listedAt, itemID, limit+1)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	var res feedResponse
+	if len(items) > limit {
+		items = items[:limit]
+		last := items[limit-1]
+		res.NextCursor = encodeFeedCursor(last.ListedAt, last.ID)
+	}
+
+	cats, err := h.ItemRepo.GetCategories(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	names := make(map[int64]string, len(cats))
+	for _, cat := range cats {
+		names[cat.ID] = cat.Name
+	}
+
+	res.Items = make([]feedItemResponse, 0, len(items))
+	for _, item := range items {
+		res.Items = append(res.Items, feedItemResponse{
+			ID:           item.ID,
+			Name:         item.Name,
+			Price:        item.Price,
+			CategoryName: names[item.CategoryID],
+			Placeholder:  item.Placeholder,
+			SellerID:     item.UserID,
+			ListedAt:     item.ListedAt,
+		})
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// notifyFollowers tells the followers who asked for it that item went on
+// sale. The item is already on sale, so failures are logged instead of returned.
+func (h *Handler) notifyFollowers(ctx context.Context, c echo.Context, item domain.Item) {
+	followerIDs, err := h.FollowRepo.GetNotifiedFollowerIDs(ctx, item.UserID)
+	if err != nil {
+		c.Logger().Error(err)
+		return
+	}
+	if len(followerIDs) == 0 {
+		return
+	}
+
+	seller, err := h.UserRepo.GetProfile(ctx, item.UserID)
+	if err != nil {
+		c.Logger().Error(err)
+		return
+	}
+	name := seller.DisplayName
+	if name == "" {
+		name = seller.Name
+	}
+
+	for _, followerID := range followerIDs {
+		err := h.NotificationRepo.AddNotification(ctx, domain.Notification{
+			UserID:  followerID,
+			Type:    domain.NotificationTypeNewListing,
+			ItemID:  item.ID,
+			Message: fmt.Sprintf("%s put %s on sale for %d", name, item.Name, item.Price),
+		})
+		if err != nil {
+			c.Logger().Error(err)
+		}
+	}
+}
+
+// A feed cursor is the listing time and ID of the last item of a page.
+func encodeFeedCursor(listedAt string, itemID int32) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(listedAt + "|" + strconv.FormatInt(int64(itemID), 10)))
+}
+
+func decodeFeedCursor(cursor string) (string, int32, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", 0, err
+	}
+	listedAt, id, ok := strings.Cut(string(b), "|")
+	if !ok || listedAt == "" {
+		return "", 0, errors.New("malformed cursor")
+	}
+	itemID, err := strconv.ParseInt(id, 10, 32)
+	if err != nil {
+		return "", 0, err
+	}
+	return listedAt, int32(itemID), nil
+}
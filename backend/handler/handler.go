@@ -3,14 +3,13 @@
 package handler
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
 	"fmt"
-	"io"
 	"log"
 	"math"
 	"net/http"
+	netmail "net/mail"
 	"os"
 	"strconv"
 	"strings"
@@ -21,8 +20,14 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/pkg/errors"
 	openai "github.com/sashabaranov/go-openai"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/blob"
 	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/db"
 	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/imageproc"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/jwtkey"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/mail"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/oidc"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/password"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -31,7 +36,9 @@ var (
 )
 
 type JwtCustomClaims struct {
-	UserID int64 `json:"user_id"`
+	UserID    int64       `json:"user_id"`
+	Role      domain.Role `json:"role"`
+	SessionID int64       `json:"sid"`
 	jwt.RegisteredClaims
 }
 
@@ -42,6 +49,7 @@ type InitializeResponse struct {
 type registerRequest struct {
 	Name     string `json:"name"`
 	Password string `json:"password"`
+	Email    string `json:"email"`
 }
 
 type registerResponse struct {
@@ -54,6 +62,7 @@ type getUserItemsResponse struct {
 	Name         string `json:"name"`
 	Price        int64  `json:"price"`
 	CategoryName string `json:"category_name"`
+	Placeholder  string `json:"placeholder,omitempty"`
 }
 
 type getOnSaleItemsResponse struct {
@@ -61,17 +70,22 @@ type getOnSaleItemsResponse struct {
 	Name         string `json:"name"`
 	Price        int64  `json:"price"`
 	CategoryName string `json:"category_name"`
+	Placeholder  string `json:"placeholder,omitempty"`
 }
 
 type getItemResponse struct {
-	ID           int32             `json:"id"`
-	Name         string            `json:"name"`
-	CategoryID   int64             `json:"category_id"`
-	CategoryName string            `json:"category_name"`
-	UserID       int64             `json:"user_id"`
-	Price        int64             `json:"price"`
-	Description  string            `json:"description"`
-	Status       domain.ItemStatus `json:"status"`
+	ID           int32                   `json:"id"`
+	Name         string                  `json:"name"`
+	CategoryID   int64                   `json:"category_id"`
+	CategoryName string                  `json:"category_name"`
+	UserID       int64                   `json:"user_id"`
+	Price        int64                   `json:"price"`
+	Description  string                  `json:"description"`
+	Status       domain.ItemStatus       `json:"status"`
+	ImageCount   int                     `json:"image_count,omitempty"`
+	Placeholder  string                  `json:"placeholder,omitempty"`
+	Breadcrumbs  []getCategoriesResponse `json:"breadcrumbs,omitempty"`
+	Attributes   map[string]string       `json:"attributes,omitempty"`
 }
 
 type getItemPasswordResponse struct {
@@ -79,8 +93,9 @@ type getItemPasswordResponse struct {
 }
 
 type getCategoriesResponse struct {
-	ID   int64  `json:"id"`
-	Name string `json:"name"`
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	ParentID int64  `json:"parent_id,omitempty"`
 }
 
 type sellRequest struct {
@@ -120,18 +135,22 @@ type getBalanceResponse struct {
 }
 
 type loginRequest struct {
+	Name     string `json:"name"`
 	UserID   int64  `json:"user_id"`
 	Password string `json:"password"`
 }
 
 type loginResponse struct {
-	ID    int64  `json:"id"`
-	Name  string `json:"name"`
-	Token string `json:"token"`
+	ID           int64  `json:"id"`
+	Name         string `json:"name"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
 }
 
 type addCategoryRequest struct {
-	Name string `json:"name"`
+	Name     string `json:"name"`
+	ParentID int64  `json:"parent_id"`
 }
 
 type addCategoryResponse struct {
@@ -173,13 +192,27 @@ type Handler struct {
 	UserRepo           db.UserRepository
 	ItemRepo           db.ItemRepository
 	OnsitePurchaseRepo db.OnsitePurchaseRepository
-}
-
-func GetSecret() string {
-	if secret := os.Getenv("SECRET"); secret != "" {
-		return secret
-	}
-	return "secret-key"
+	PriceWatchRepo     db.PriceWatchRepository
+	NotificationRepo   db.NotificationRepository
+	PurchaseRepo       db.PurchaseRepository
+	DuplicateRepo      db.DuplicateRepository
+	AttributeRepo      db.AttributeRepository
+	SessionRepo        db.SessionRepository
+	LoginAttemptRepo   db.LoginAttemptRepository
+	PasswordResetRepo  db.PasswordResetRepository
+	TwoFactorRepo      db.TwoFactorRepository
+	IdentityRepo       db.IdentityRepository
+	APIKeyRepo         db.APIKeyRepository
+	ReviewRepo         db.ReviewRepository
+	FollowRepo         db.FollowRepository
+	BlobStore          blob.Store
+	ImagePool          *imageproc.Pool
+	ImageLimits        imageproc.Limits
+	Keys               *jwtkey.Manager
+	PasswordPolicy     password.Policy
+	Mailer             mail.Sender
+	// OIDC is nil unless an OpenID Connect provider is configured.
+	OIDC *oidc.Provider
 }
 
 func (h *Handler) Initialize(c echo.Context) error {
@@ -193,6 +226,20 @@ func (h *Handler) Initialize(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, errors.Wrap(err, "Failed to initialize"))
 	}
 
+	// Seed data carries its images inline. They are processed like uploads,
+	// which takes a while, so they follow in the background; until then the
+	// seed items have no image.
+	logger := c.Logger()
+	go func() {
+		_, skipped, err := db.MigrateImagesToBlobStore(context.Background(), h.DB, h.BlobStore)
+		if err != nil {
+			logger.Errorf("failed to move seed images to the blob store: %s", err)
+		}
+		if len(skipped) > 0 {
+			logger.Errorf("seed images of items %v fail to decode", skipped)
+		}
+	}()
+
 	return c.JSON(http.StatusOK, InitializeResponse{Message: "Success"})
 }
 
@@ -213,8 +260,22 @@ func (h *Handler) Register(c echo.Context) error {
 	if len(req.Name) == 0 {
 		return echo.NewHTTPError(http.StatusBadRequest, "name is invalid")
 	}
-	if len(req.Password) == 0 {
-		return echo.NewHTTPError(http.StatusBadRequest, "password is invalid")
+	if err := h.PasswordPolicy.Check(req.Password, req.Name); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if req.Email != "" {
+		// Only the address itself is kept, never a display name
+		addr, err := netmail.ParseAddress(req.Email)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "email is invalid")
+		}
+		req.Email = addr.Address
+	}
+
+	if _, err := h.UserRepo.GetUserByName(c.Request().Context(), req.Name); err == nil {
+		return echo.NewHTTPError(http.StatusConflict, "name is already taken")
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
 	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
@@ -222,7 +283,7 @@ func (h *Handler) Register(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
-	userID, err := h.UserRepo.AddUser(c.Request().Context(), domain.User{Name: req.Name, Password: string(hash)})
+	userID, err := h.UserRepo.AddUser(c.Request().Context(), domain.User{Name: req.Name, Password: string(hash), Email: req.Email})
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
@@ -245,37 +306,74 @@ func (h *Handler) Login(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "password is invalid")
 	}
 
-	user, err := h.UserRepo.GetUser(ctx, req.UserID)
+	if req.Name == "" && req.UserID == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "name is required")
+	}
+
+	// Users log in by name; the numeric user_id is still accepted
+	var user domain.User
+	var err error
+	login := req.Name
+	if login != "" {
+		user, err = h.UserRepo.GetUserByName(ctx, login)
+	} else {
+		login = strconv.FormatInt(req.UserID, 10)
+		user, err = h.UserRepo.GetUser(ctx, req.UserID)
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	attempt := domain.LoginAttempt{UserID: user.ID, Login: login, IP: c.RealIP()}
+
+	// The attempt counts as failed until the password checks out, so
+	// concurrent attempts see each other and can't all get past the throttle
+	attemptID, err := h.LoginAttemptRepo.AddLoginAttempt(ctx, attempt)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
+	wait, err := h.loginBackoff(ctx, attempt, attemptID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if wait > 0 {
+		// A throttled attempt never checked the password
+		if err := h.LoginAttemptRepo.DeleteLoginAttempt(ctx, attemptID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+		c.Response().Header().Set(echo.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return echo.NewHTTPError(http.StatusTooManyRequests, "Too many failed logins, try again later")
+	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-		if err == bcrypt.ErrMismatchedHashAndPassword {
-			return echo.NewHTTPError(http.StatusUnauthorized, err)
+	if err := checkPassword(user, req.Password); err != nil {
+		if err != bcrypt.ErrMismatchedHashAndPassword {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
 		}
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid name or password")
+	}
+
+	if err := h.LoginAttemptRepo.SetLoginAttemptSucceeded(ctx, attemptID); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
-	// Set custom claims
-	claims := &JwtCustomClaims{
-		req.UserID,
-		jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 72)),
-		},
+	enabled, err := h.twoFactorEnabled(ctx, user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if enabled {
+		return h.startTwoFactorChallenge(c, user)
 	}
-	// Create token with claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	// Generate encoded token and send it as response.
-	encodedToken, err := token.SignedString([]byte(GetSecret()))
+
+	accessToken, refreshToken, err := h.startSession(c, user)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
 	return c.JSON(http.StatusOK, loginResponse{
-		ID:    user.ID,
-		Name:  user.Name,
-		Token: encodedToken,
+		ID:           user.ID,
+		Name:         user.Name,
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(accessTokenTTL / time.Second),
 	})
 }
 
@@ -292,18 +390,17 @@ func (h *Handler) AddItem(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, err)
 	}
 
-	file, err := c.FormFile("image")
+	if _, err := c.FormFile("image"); err != nil {
+		return uploadError(reasonMissingImage, "image is required")
+	}
+
+	// Further images uploaded under the same field are stored after the primary one
+	images, err := h.readImageFiles(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err)
+		return err
 	}
 
 	// validation
-	// if file.Size > 1<<20 {
-	// 	return echo.NewHTTPError(http.StatusBadRequest, "image size must be less than 1MB")
-	// }
-	// if file.Size == 0 {
-	// 	return echo.NewHTTPError(http.StatusBadRequest, "image must not be empty")
-	// }
 	// if req.Price <= 0 {
 	// 	return echo.NewHTTPError(http.StatusBadRequest, "price must be greater than 0")
 	// }
@@ -317,61 +414,32 @@ func (h *Handler) AddItem(c echo.Context) error {
 	// end of validation
 	// end of validation
 
-	src, err := file.Open()
-	if err != nil {
+	// Check if the category exists
+	if _, err := h.ItemRepo.GetCategory(ctx, req.CategoryID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Category does not exist")
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
-	defer src.Close()
-
-	// // check the file type
-	// // Read the first 512 bytes to determine the file type
-	// buffer := make([]byte, 512)
-	// _, err = src.Read(buffer)
-	// if err != nil {
-	// 	return echo.NewHTTPError(http.StatusInternalServerError, err)
-	// }
 
-	// // Reset the reader back to the start of the file
-	// _, err = src.Seek(0, io.SeekStart)
-	// if err != nil {
-	// 	return echo.NewHTTPError(http.StatusInternalServerError, err)
-	// }
-
-	// // Detect the content type of the file
-	// contentType := http.DetectContentType(buffer)
-	// if !strings.HasPrefix(contentType, "image/") {
-	// 	return echo.NewHTTPError(http.StatusBadRequest, "uploaded file is not an image")
-	// }
-
-	var dest []byte
-	blob := bytes.NewBuffer(dest)
-
-	// separate the copy operation into a goroutine
-	errCh := make(chan error)
-
-	go func() {
-		if _, err := io.Copy(blob, src); err != nil {
-			errCh <- err
-		}
-		close(errCh)
-	}()
+	attrs, err := readAttributes(c)
+	if err != nil {
+		return err
+	}
+	if attrs, err = h.validateAttributes(ctx, req.CategoryID, attrs); err != nil {
+		return err
+	}
 
-	// Check if the category exists
-	categoryCh := make(chan error)
-	go func() {
-		_, err = h.ItemRepo.GetCategory(ctx, req.CategoryID)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				categoryCh <- errors.New("Category does not exist")
-			}
-			categoryCh <- err
-		}
-		close(categoryCh)
-	}()
+	// Uploads are processed into their variants before anything is stored
+	hashes, err := h.putImages(ctx, images)
+	if err != nil {
+		return err
+	}
 
-	// We must ensure the copy operation has finished before we can use the blob
-	if err = <-errCh; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("copy operation failed: %v", err))
+	// Scammers repost the same photo across listings
+	duplicates, err := h.checkNewImages(ctx, nil, hashes)
+	if err != nil {
+		return err
 	}
 
 	item, err := h.ItemRepo.AddItem(c.Request().Context(), domain.Item{
@@ -380,7 +448,7 @@ func (h *Handler) AddItem(c echo.Context) error {
 		UserID:      userID,
 		Price:       req.Price,
 		Description: req.Description,
-		Image:       blob.Bytes(),
+		ImageHash:   hashes[0],
 		Status:      domain.ItemStatusInitial,
 	})
 	if err != nil {
@@ -396,6 +464,22 @@ func (h *Handler) AddItem(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
+	if len(hashes) > 1 {
+		if err := h.ItemRepo.SetItemImages(ctx, item.ID, hashes, userID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+	}
+
+	if len(attrs) > 0 {
+		if err := h.AttributeRepo.SetItemAttributes(ctx, item.ID, attrs); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+	}
+
+	if err := h.flagDuplicates(ctx, item.ID, duplicates); err != nil {
+		return err
+	}
+
 	return c.JSON(http.StatusOK, addItemResponse{ID: int64(item.ID)})
 }
 
@@ -431,9 +515,14 @@ func (h *Handler) EditItem(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "User is not the owner of the item")
 	}
 
-	file, err := c.FormFile("image")
+	if _, err := c.FormFile("image"); err != nil {
+		return uploadError(reasonMissingImage, "image is required")
+	}
+
+	// Like the item fields, the uploaded images replace all existing ones
+	images, err := h.readImageFiles(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err)
+		return err
 	}
 
 	// validation
@@ -456,69 +545,38 @@ func (h *Handler) EditItem(c echo.Context) error {
 	// 	return echo.NewHTTPError(http.StatusBadRequest, "image must not be empty")
 	// }
 
-	src, err := file.Open()
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err)
-	}
-	defer src.Close()
-
-	// check the file type
-	// Read the first 512 bytes to determine the file type
-	// buffer := make([]byte, 512)
-	// _, err = src.Read(buffer)
-	// if err != nil {
-	// 	return echo.NewHTTPError(http.StatusInternalServerError, err)
-	// }
-
-	// // Reset the reader back to the start of the file
-	// _, err = src.Seek(0, io.SeekStart)
-	// if err != nil {
-	// 	return echo.NewHTTPError(http.StatusInternalServerError, err)
-	// }
-
-	// // Detect the content type of the file
-	// contentType := http.DetectContentType(buffer)
-	// if !strings.HasPrefix(contentType, "image/") {
-	// 	return echo.NewHTTPError(http.StatusBadRequest, "uploaded file is not an image")
-	// }
-
-	var dest []byte
-	blob := bytes.NewBuffer(dest)
-
-	// separate the copy operation into a goroutine
-	errCh := make(chan error)
-
-	go func() {
-		if _, err := io.Copy(blob, src); err != nil {
-			errCh <- err
-		}
-		close(errCh)
-	}()
-
 	// if file.Header.Get("Content-Type") != "image/png" && file.Header.Get("Content-Type") != "image/jpeg" {
 	// 	return echo.NewHTTPError(http.StatusBadRequest, "image must be png or jpeg")
 	// }
 
 	// Check if the category exists
-	//
-	categoryCh := make(chan error)
-	go func() {
-		_, err = h.ItemRepo.GetCategory(ctx, req.CategoryID)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				categoryCh <- errors.New("Category does not exist")
-			}
-			categoryCh <- err
+	if _, err := h.ItemRepo.GetCategory(ctx, req.CategoryID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Category does not exist")
 		}
-		close(categoryCh)
-	}()
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
 
-	if err = <-errCh; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to copy image file")
+	// Like the images, the submitted attributes replace all existing ones
+	attrs, err := readAttributes(c)
+	if err != nil {
+		return err
+	}
+	if attrs, err = h.validateAttributes(ctx, req.CategoryID, attrs); err != nil {
+		return err
 	}
 
-	if err = <-categoryCh; err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	hashes, err := h.putImages(ctx, images)
+	if err != nil {
+		return err
+	}
+	oldHashes, err := h.ItemRepo.GetItemImageHashes(ctx, existingItem.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	duplicates, err := h.checkNewImages(ctx, oldHashes, hashes)
+	if err != nil {
+		return err
 	}
 
 	item, err := h.ItemRepo.EditItem(c.Request().Context(), domain.Item{
@@ -528,13 +586,27 @@ func (h *Handler) EditItem(c echo.Context) error {
 		UserID:      userID,
 		Price:       req.Price,
 		Description: req.Description,
-		Image:       blob.Bytes(),
+		ImageHash:   hashes[0],
 		Status:      domain.ItemStatusInitial,
-	})
+	}, userID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
+	if err := h.ItemRepo.SetItemImages(ctx, item.ID, hashes, userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	if err := h.AttributeRepo.SetItemAttributes(ctx, item.ID, attrs); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	if err := h.flagDuplicates(ctx, item.ID, duplicates); err != nil {
+		return err
+	}
+
+	h.notifyPriceDrop(ctx, c, item, existingItem.Price)
+
 	return c.JSON(http.StatusOK, editItemResponse{ID: int64(item.ID)})
 }
 func (h *Handler) Sell(c echo.Context) error {
@@ -574,6 +646,10 @@ func (h *Handler) Sell(c echo.Context) error {
 	if err := h.ItemRepo.UpdateItemStatus(ctx, item.ID, domain.ItemStatusOnSale); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
+	// Followers hear about an item once, not each time it goes back on sale
+	if item.ListedAt == "" {
+		h.notifyFollowers(ctx, c, item)
+	}
 
 	return c.JSON(http.StatusOK, "successful")
 }
@@ -591,6 +667,10 @@ func (h *Handler) GetOnSaleItems(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
+	if items, err = h.filterByAttributes(c, items); err != nil {
+		return err
+	}
+
 	var res []getOnSaleItemsResponse
 	for _, item := range items {
 		cats, err := h.ItemRepo.GetCategories(ctx)
@@ -599,7 +679,7 @@ func (h *Handler) GetOnSaleItems(c echo.Context) error {
 		}
 		for _, cat := range cats {
 			if cat.ID == item.CategoryID {
-				res = append(res, getOnSaleItemsResponse{ID: item.ID, Name: item.Name, Price: item.Price, CategoryName: cat.Name})
+				res = append(res, getOnSaleItemsResponse{ID: item.ID, Name: item.Name, Price: item.Price, CategoryName: cat.Name, Placeholder: item.Placeholder})
 			}
 		}
 	}
@@ -625,11 +705,38 @@ func (h *Handler) GetItem(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
-	category, err := h.ItemRepo.GetCategory(ctx, item.CategoryID)
+	path, err := h.ItemRepo.GetCategoryPath(ctx, item.CategoryID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if len(path) == 0 {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Category of the item does not exist")
+	}
+	category := path[len(path)-1]
+
+	breadcrumbs := make([]getCategoriesResponse, len(path))
+	for i, cat := range path {
+		breadcrumbs[i] = getCategoriesResponse{ID: cat.ID, Name: cat.Name, ParentID: cat.ParentID}
+	}
+
+	imageCount, err := h.ItemRepo.CountItemImages(ctx, item.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	attrs, err := h.AttributeRepo.GetItemAttributes(ctx, item.ID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
-	return c.JSON(http.StatusOK, getItemResponse{
+
+	// The response shows the names of the item's categories, so renaming or
+	// moving one of them modifies it too.
+	updates := []string{item.UpdatedAt}
+	for _, cat := range path {
+		updates = append(updates, cat.UpdatedAt)
+	}
+
+	return cachedJSON(c, latestDBTime(updates...), getItemResponse{
 		ID:           item.ID,
 		Name:         item.Name,
 		CategoryID:   item.CategoryID,
@@ -638,6 +745,10 @@ func (h *Handler) GetItem(c echo.Context) error {
 		Price:        item.Price,
 		Description:  item.Description,
 		Status:       item.Status,
+		ImageCount:   imageCount,
+		Placeholder:  item.Placeholder,
+		Breadcrumbs:  breadcrumbs,
+		Attributes:   attrs,
 	})
 }
 
@@ -684,17 +795,20 @@ func (h *Handler) GetUserItems(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
-	var res []getUserItemsResponse
-	for _, item := range items {
-		cats, err := h.ItemRepo.GetCategories(ctx)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, err)
-		}
-		for _, cat := range cats {
-			if cat.ID == item.CategoryID {
-				res = append(res, getUserItemsResponse{ID: item.ID, Name: item.Name, Price: item.Price, CategoryName: cat.Name})
+	// Sellers see all their items, everyone else only the ones on sale
+	if currentID, err := getUserID(c); err != nil || currentID != userID {
+		var onSale []domain.Item
+		for _, item := range items {
+			if item.Status == domain.ItemStatusOnSale {
+				onSale = append(onSale, item)
 			}
 		}
+		items = onSale
+	}
+
+	res, err := h.userItemsResponse(ctx, items)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
 	return c.JSON(http.StatusOK, res)
@@ -715,10 +829,12 @@ func (h *Handler) GetCategories(c echo.Context) error {
 
 	res := make([]getCategoriesResponse, len(cats))
 	for i, cat := range cats {
-		res[i] = getCategoriesResponse{ID: cat.ID, Name: cat.Name}
+		res[i] = getCategoriesResponse{ID: cat.ID, Name: cat.Name, ParentID: cat.ParentID}
 	}
 
-	return c.JSON(http.StatusOK, res)
+	// Deleted categories leave no change time behind, so category lists
+	// are only validated by their ETag.
+	return cachedJSON(c, time.Time{}, res)
 }
 
 func (h *Handler) GetImage(c echo.Context) error {
@@ -733,7 +849,12 @@ func (h *Handler) GetImage(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "ItemID out of range")
 	}
 
-	data, err := h.ItemRepo.GetItemImage(ctx, int32(itemID))
+	size, err := parseImageSize(c)
+	if err != nil {
+		return err
+	}
+
+	key, err := h.ItemRepo.GetItemImageKey(ctx, int32(itemID), 0, size)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return echo.NewHTTPError(http.StatusNotFound, "Image not found")
@@ -741,11 +862,7 @@ func (h *Handler) GetImage(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
-	contentType := http.DetectContentType(data[:512])
-	if !strings.HasPrefix(contentType, "image/") {
-		return echo.NewHTTPError(http.StatusNotFound, "uploaded file is not an image")
-	}
-	return c.Blob(http.StatusOK, contentType, data)
+	return h.serveImage(c, key)
 }
 
 func (h *Handler) AddBalance(c echo.Context) error {
@@ -764,6 +881,10 @@ func (h *Handler) AddBalance(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, err)
 	}
 
+	if err := h.requireTwoFactorStepUp(c, userID, req.Balance); err != nil {
+		return err
+	}
+
 	user, err := h.UserRepo.GetUser(ctx, userID)
 	// TODO: not found handling
 	// http.StatusPreconditionFailed(412)
@@ -857,6 +978,10 @@ func (h *Handler) Purchase(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusPreconditionFailed, "Insufficient balance")
 	}
 
+	if err := h.requireTwoFactorStepUp(c, userID, item.Price); err != nil {
+		return err
+	}
+
 	// Continue with the status update if the item is on sale and user has enough balance to finish the transactions.
 	if err := h.ItemRepo.UpdateItemStatus(ctx, int32(itemID), domain.ItemStatusSoldOut); err != nil {
 		c.Logger().Error(err)
@@ -885,6 +1010,11 @@ func (h *Handler) Purchase(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error.")
 	}
 
+	if err := h.PurchaseRepo.AddPurchase(ctx, domain.Purchase{ItemID: item.ID, BuyerID: userID, SellerID: sellerID, Price: item.Price}); err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error.")
+	}
+
 	return c.JSON(http.StatusOK, "successful")
 }
 
@@ -949,6 +1079,10 @@ func (h *Handler) OnsitePurchase(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusPreconditionFailed, "Insufficient balance")
 	}
 
+	if err := h.requireTwoFactorStepUp(c, userID, item.Price); err != nil {
+		return err
+	}
+
 	isValid, err := h.OnsitePurchaseRepo.ValidatePassword(ctx, itemID, req.Password)
 	if err != nil {
 		c.Logger().Error(err)
@@ -970,6 +1104,11 @@ func (h *Handler) OnsitePurchase(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error.")
 	}
 
+	if err := h.PurchaseRepo.AddPurchase(ctx, domain.Purchase{ItemID: item.ID, BuyerID: userID, SellerID: item.UserID, Price: item.Price}); err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error.")
+	}
+
 	return c.JSON(http.StatusOK, "successful")
 }
 
@@ -1018,6 +1157,10 @@ func (h *Handler) SearchItemByKeyword(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error")
 	}
 
+	if items, err = h.filterByAttributes(c, items); err != nil {
+		return err
+	}
+
 	// return the response
 	var res []getUserItemsResponse
 	for _, item := range items {
@@ -1028,7 +1171,7 @@ func (h *Handler) SearchItemByKeyword(c echo.Context) error {
 		}
 		for _, cat := range cats {
 			if cat.ID == item.CategoryID {
-				res = append(res, getUserItemsResponse{ID: item.ID, Name: item.Name, Price: item.Price, CategoryName: cat.Name})
+				res = append(res, getUserItemsResponse{ID: item.ID, Name: item.Name, Price: item.Price, CategoryName: cat.Name, Placeholder: item.Placeholder})
 			}
 		}
 	}
@@ -1057,6 +1200,10 @@ func (h *Handler) SearchItemAndInfoByKeyword(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Internal server error")
 	}
 
+	if items, err = h.filterByAttributes(c, items); err != nil {
+		return err
+	}
+
 	// return the response
 	var res []getItemResponse
 	for _, item := range items {
@@ -1069,7 +1216,7 @@ func (h *Handler) SearchItemAndInfoByKeyword(c echo.Context) error {
 			if cat.ID == item.CategoryID {
 				res = append(res, getItemResponse{ID: item.ID, Name: item.Name, CategoryID: item.CategoryID,
 					CategoryName: cat.Name, Price: item.Price,
-					Description: item.Description, Status: item.Status})
+					Description: item.Description, Status: item.Status, Placeholder: item.Placeholder})
 			}
 		}
 	}
@@ -1131,6 +1278,14 @@ func getEnv(key string, defaultValue string) string {
 	return value
 }
 
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
 // AddCategory API
 func (h *Handler) AddCategory(c echo.Context) error {
 	ctx := c.Request().Context()
@@ -1140,8 +1295,13 @@ func (h *Handler) AddCategory(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err)
 	}
 
+	req.Name = domain.CleanCategoryName(req.Name)
+	if req.Name == "" || len(req.Name) > 50 {
+		return echo.NewHTTPError(http.StatusBadRequest, "name must be between 1 and 50 characters")
+	}
+
 	// Check if category already exists
-	_, err := h.ItemRepo.GetCategoryByName(ctx, req.Name)
+	_, err := h.ItemRepo.GetCategoryByName(ctx, req.ParentID, req.Name)
 	if err != nil {
 		if err != sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusInternalServerError, err)
@@ -1151,15 +1311,25 @@ func (h *Handler) AddCategory(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Category already exists")
 	}
 
+	if req.ParentID != 0 {
+		if _, err := h.ItemRepo.GetCategory(ctx, req.ParentID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return echo.NewHTTPError(http.StatusBadRequest, "Parent category does not exist")
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+	}
+
 	// If category does not exist, proceed to create it
 	category, err := h.ItemRepo.AddCategory(ctx, domain.Category{
-		Name: req.Name,
+		Name:     req.Name,
+		ParentID: req.ParentID,
 	})
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
-	return c.JSON(http.StatusOK, addCategoryResponse{ID: int64(category.ID)})
+	return c.JSON(http.StatusOK, addCategoryResponse{ID: int64(category.ID), Name: category.Name})
 }
 
 // search by category api
@@ -1174,12 +1344,31 @@ func (h *Handler) GetItemsByCategory(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid category ID")
 	}
 
+	// ?descendants=true includes the items of every subcategory
+	descendants, _ := strconv.ParseBool(c.QueryParam("descendants"))
+
+	// read before the items so that a concurrent change can't be dated
+	// earlier than the response showing it
+	lastModified, err := h.ItemRepo.GetLastModified(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
 	// repo call
-	items, err := h.ItemRepo.GetItemsByCategory(ctx, categoryID)
+	var items []domain.Item
+	if descendants {
+		items, err = h.ItemRepo.GetItemsInCategoryTree(ctx, categoryID)
+	} else {
+		items, err = h.ItemRepo.GetItemsByCategory(ctx, categoryID)
+	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
+	if items, err = h.filterByAttributes(c, items); err != nil {
+		return err
+	}
+
 	var res []getUserItemsResponse
 	for _, item := range items {
 		cats, err := h.ItemRepo.GetCategories(ctx)
@@ -1188,12 +1377,12 @@ func (h *Handler) GetItemsByCategory(c echo.Context) error {
 		}
 		for _, cat := range cats {
 			if cat.ID == item.CategoryID {
-				res = append(res, getUserItemsResponse{ID: item.ID, Name: item.Name, Price: item.Price, CategoryName: cat.Name})
+				res = append(res, getUserItemsResponse{ID: item.ID, Name: item.Name, Price: item.Price, CategoryName: cat.Name, Placeholder: item.Placeholder})
 			}
 		}
 	}
 
-	return c.JSON(http.StatusOK, res)
+	return cachedJSON(c, parseDBTime(lastModified), res)
 }
 
 // GPT 3 API
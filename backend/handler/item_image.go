@@ -0,0 +1,346 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/imageproc"
+)
+
+var (
+	maxItemImages = getEnvInt("MAX_ITEM_IMAGES", 10)
+)
+
+// Reasons for rejected uploads besides the ones reported by imageproc.
+const (
+	reasonMissingImage  = "missing_image"
+	reasonTooManyImages = "too_many_images"
+)
+
+// uploadErrorResponse is the body of every 400 caused by an uploaded image.
+type uploadErrorResponse struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+type reorderItemImagesRequest struct {
+	Order []int `json:"order"`
+}
+
+type itemImagesResponse struct {
+	ID         int64 `json:"id"`
+	ImageCount int   `json:"image_count"`
+}
+
+// GetItemImageAt serves the n-th image of an item, 0 being the primary image.
+func (h *Handler) GetItemImageAt(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	itemID, err := strconv.ParseInt(c.Param("itemID"), 10, 32)
+	if err != nil || itemID <= 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid itemID")
+	}
+	n, err := strconv.Atoi(c.Param("n"))
+	if err != nil || n < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid image index")
+	}
+	size, err := parseImageSize(c)
+	if err != nil {
+		return err
+	}
+
+	key, err := h.ItemRepo.GetItemImageKey(ctx, int32(itemID), n, size)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "Image not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return h.serveImage(c, key)
+}
+
+// AddItemImages appends the uploaded images after the existing ones.
+func (h *Handler) AddItemImages(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	item, userID, err := h.getOwnedItem(c)
+	if err != nil {
+		return err
+	}
+
+	uploaded, err := h.readImageFiles(c)
+	if err != nil {
+		return err
+	}
+	if len(uploaded) == 0 {
+		return uploadError(reasonMissingImage, "image is required")
+	}
+
+	hashes, err := h.ItemRepo.GetItemImageHashes(ctx, item.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if len(hashes)+len(uploaded) > maxItemImages {
+		return uploadError(reasonTooManyImages, fmt.Sprintf("an item can have at most %d images", maxItemImages))
+	}
+
+	uploadedHashes, err := h.putImages(ctx, uploaded)
+	if err != nil {
+		return err
+	}
+	updated := append(append([]string(nil), hashes...), uploadedHashes...)
+	duplicates, err := h.checkNewImages(ctx, hashes, updated)
+	if err != nil {
+		return err
+	}
+	hashes = updated
+
+	if err := h.ItemRepo.SetItemImages(ctx, item.ID, hashes, userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if err := h.flagDuplicates(ctx, item.ID, duplicates); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, itemImagesResponse{ID: int64(item.ID), ImageCount: len(hashes)})
+}
+
+func (h *Handler) DeleteItemImage(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	item, userID, err := h.getOwnedItem(c)
+	if err != nil {
+		return err
+	}
+
+	n, err := strconv.Atoi(c.Param("n"))
+	if err != nil || n < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid image index")
+	}
+
+	hashes, err := h.ItemRepo.GetItemImageHashes(ctx, item.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if n >= len(hashes) {
+		return echo.NewHTTPError(http.StatusNotFound, "Image not found")
+	}
+	if len(hashes) == 1 {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "an item must keep at least one image")
+	}
+	remaining := append(append([]string(nil), hashes[:n]...), hashes[n+1:]...)
+	// Deleting the primary image promotes the next one
+	duplicates, err := h.checkNewImages(ctx, hashes, remaining)
+	if err != nil {
+		return err
+	}
+	hashes = remaining
+
+	if err := h.ItemRepo.SetItemImages(ctx, item.ID, hashes, userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if err := h.flagDuplicates(ctx, item.ID, duplicates); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, itemImagesResponse{ID: int64(item.ID), ImageCount: len(hashes)})
+}
+
+// ReorderItemImages rearranges the images by a permutation of their current
+// indexes, e.g. [2, 0, 1] makes the third image the primary one.
+func (h *Handler) ReorderItemImages(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	req := new(reorderItemImagesRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	item, userID, err := h.getOwnedItem(c)
+	if err != nil {
+		return err
+	}
+
+	hashes, err := h.ItemRepo.GetItemImageHashes(ctx, item.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if len(req.Order) != len(hashes) {
+		return echo.NewHTTPError(http.StatusBadRequest, "order must list every image exactly once")
+	}
+
+	seen := make([]bool, len(hashes))
+	reordered := make([]string, len(hashes))
+	for i, n := range req.Order {
+		if n < 0 || n >= len(hashes) || seen[n] {
+			return echo.NewHTTPError(http.StatusBadRequest, "order must list every image exactly once")
+		}
+		seen[n] = true
+		reordered[i] = hashes[n]
+	}
+	duplicates, err := h.checkNewImages(ctx, hashes, reordered)
+	if err != nil {
+		return err
+	}
+
+	if err := h.ItemRepo.SetItemImages(ctx, item.ID, reordered, userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if err := h.flagDuplicates(ctx, item.ID, duplicates); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, itemImagesResponse{ID: int64(item.ID), ImageCount: len(reordered)})
+}
+
+// getOwnedItem loads the item of the request for its seller, rejecting sold items.
+func (h *Handler) getOwnedItem(c echo.Context) (domain.Item, int64, error) {
+	itemID, err := strconv.ParseInt(c.Param("itemID"), 10, 32)
+	if err != nil {
+		return domain.Item{}, 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid itemID")
+	}
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return domain.Item{}, 0, echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	item, err := h.ItemRepo.GetItem(c.Request().Context(), int32(itemID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Item{}, 0, echo.NewHTTPError(http.StatusNotFound, "Item not found")
+		}
+		return domain.Item{}, 0, echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if item.UserID != userID {
+		return domain.Item{}, 0, echo.NewHTTPError(http.StatusForbidden, "User is not the owner of the item")
+	}
+	if item.Status == domain.ItemStatusSoldOut {
+		return domain.Item{}, 0, echo.NewHTTPError(http.StatusPreconditionFailed, "Sold items cannot be edited")
+	}
+
+	return item, userID, nil
+}
+
+// putImages stores uploaded images and returns their keys in the same order.
+// An image failing validation is rejected with uploadError and its reason.
+func (h *Handler) putImages(ctx context.Context, images [][]byte) ([]string, error) {
+	hashes := make([]string, len(images))
+	for i, image := range images {
+		hash, err := h.storeImage(ctx, image)
+		if err != nil {
+			var invalid *imageproc.ValidationError
+			if errors.As(err, &invalid) {
+				return nil, uploadError(invalid.Reason, invalid.Message)
+			}
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+		hashes[i] = hash
+	}
+	return hashes, nil
+}
+
+// storeImage processes an upload into its variants and stores them all. The
+// original bytes, metadata included, are never stored: the full size variant
+// stands for the image from then on, so its key is returned.
+func (h *Handler) storeImage(ctx context.Context, data []byte) (string, error) {
+	variants, err := h.ImagePool.Process(ctx, data)
+	if err != nil {
+		return "", err
+	}
+
+	hashes := make(map[domain.ImageSize]string, len(variants))
+	for size, variant := range variants {
+		hash, err := h.ItemRepo.PutImage(ctx, variant)
+		if err != nil {
+			return "", err
+		}
+		hashes[size] = hash
+	}
+
+	full := hashes[domain.ImageSizeFull]
+	if err := h.ItemRepo.AddImageVariants(ctx, full, hashes); err != nil {
+		return "", err
+	}
+
+	// The thumbnail is plenty for a perceptual hash or a colour and the cheapest to decode
+	phash, err := imageproc.DHash(variants[domain.ImageSizeThumb])
+	if err != nil {
+		return "", err
+	}
+	if err := h.DuplicateRepo.AddImageFingerprint(ctx, full, phash); err != nil {
+		return "", err
+	}
+	color, err := imageproc.DominantColor(variants[domain.ImageSizeThumb])
+	if err != nil {
+		return "", err
+	}
+	if err := h.ItemRepo.AddImagePlaceholder(ctx, full, color); err != nil {
+		return "", err
+	}
+	return full, nil
+}
+
+// parseImageSize reads the optional size query parameter, defaulting to full size.
+func parseImageSize(c echo.Context) (domain.ImageSize, error) {
+	switch size := domain.ImageSize(c.QueryParam("size")); size {
+	case "":
+		return domain.ImageSizeFull, nil
+	case domain.ImageSizeThumb, domain.ImageSizeMedium, domain.ImageSizeFull:
+		return size, nil
+	default:
+		return "", echo.NewHTTPError(http.StatusBadRequest, "size must be one of thumb, medium or full")
+	}
+}
+
+// readImageFiles reads and validates every file uploaded under the "image"
+// field, in order.
+func (h *Handler) readImageFiles(c echo.Context) ([][]byte, error) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	files := form.File["image"]
+	if len(files) > maxItemImages {
+		return nil, uploadError(reasonTooManyImages, fmt.Sprintf("an item can have at most %d images", maxItemImages))
+	}
+
+	images := make([][]byte, 0, len(files))
+	for _, file := range files {
+		// Checked before reading so oversized files are never loaded
+		if file.Size > h.ImageLimits.MaxBytes {
+			return nil, uploadError(imageproc.ReasonFileTooLarge, fmt.Sprintf("image must be at most %d bytes", h.ImageLimits.MaxBytes))
+		}
+		src, err := file.Open()
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+		image, err := io.ReadAll(src)
+		src.Close()
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to copy image file")
+		}
+		if err := imageproc.Validate(image, h.ImageLimits); err != nil {
+			var invalid *imageproc.ValidationError
+			if errors.As(err, &invalid) {
+				return nil, uploadError(invalid.Reason, invalid.Message)
+			}
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+func uploadError(reason, message string) error {
+	return echo.NewHTTPError(http.StatusBadRequest, uploadErrorResponse{Reason: reason, Message: message})
+}
@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+type fieldChangeResponse struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+type getItemRevisionsResponse struct {
+	ID          int64                 `json:"id"`
+	EditorID    int64                 `json:"editor_id"`
+	Name        string                `json:"name"`
+	Price       int64                 `json:"price"`
+	Description string                `json:"description"`
+	CategoryID  int64                 `json:"category_id"`
+	ImageHash   string                `json:"image_hash"`
+	Changes     []fieldChangeResponse `json:"changes"`
+	CreatedAt   string                `json:"created_at"`
+}
+
+type revertItemResponse struct {
+	ID int64 `json:"id"`
+}
+
+// GetItemRevisions lists the previous versions of an item. Each revision holds
+// the listing as it was until CreatedAt, so buyers can compare it against the
+// time of their purchase.
+func (h *Handler) GetItemRevisions(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	item, userID, err := h.getItemForRevisions(c)
+	if err != nil {
+		return err
+	}
+
+	allowed, err := h.canViewRevisions(c, userID, item)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if !allowed {
+		return echo.NewHTTPError(http.StatusForbidden, "Only the seller, the buyer or an admin can view revisions")
+	}
+
+	revisions, err := h.ItemRepo.GetItemRevisions(ctx, item.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	res := make([]getItemRevisionsResponse, len(revisions))
+	for i, rev := range revisions {
+		changes := make([]fieldChangeResponse, len(rev.Changes))
+		for j, change := range rev.Changes {
+			changes[j] = fieldChangeResponse{Field: change.Field, Old: change.Old, New: change.New}
+		}
+		res[i] = getItemRevisionsResponse{
+			ID:          rev.ID,
+			EditorID:    rev.EditorID,
+			Name:        rev.Name,
+			Price:       rev.Price,
+			Description: rev.Description,
+			CategoryID:  rev.CategoryID,
+			ImageHash:   rev.ImageHash,
+			Changes:     changes,
+			CreatedAt:   rev.CreatedAt,
+		}
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) GetItemRevisionImage(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	item, userID, err := h.getItemForRevisions(c)
+	if err != nil {
+		return err
+	}
+
+	allowed, err := h.canViewRevisions(c, userID, item)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if !allowed {
+		return echo.NewHTTPError(http.StatusForbidden, "Only the seller, the buyer or an admin can view revisions")
+	}
+
+	rev, err := h.getRevision(c, item.ID)
+	if err != nil {
+		return err
+	}
+
+	data, err := h.ItemRepo.GetImage(ctx, rev.ImageHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "Image not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "image/") {
+		return echo.NewHTTPError(http.StatusNotFound, "Image not found")
+	}
+	return c.Blob(http.StatusOK, contentType, data)
+}
+
+// RevertItem restores the listing to a previous revision. The revert is an
+// edit in its own right, so the state it replaces becomes a new revision.
+func (h *Handler) RevertItem(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	item, userID, err := h.getItemForRevisions(c)
+	if err != nil {
+		return err
+	}
+
+	if item.UserID != userID && !hasRole(c, domain.RoleModerator) {
+		return echo.NewHTTPError(http.StatusForbidden, "Only the seller or a moderator can revert an item")
+	}
+	if item.Status == domain.ItemStatusSoldOut {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "Sold items cannot be reverted")
+	}
+
+	rev, err := h.getRevision(c, item.ID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := h.ItemRepo.GetCategory(ctx, rev.CategoryID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusPreconditionFailed, "Category of the revision no longer exists")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	duplicates, err := h.checkNewImages(ctx, []string{item.ImageHash}, []string{rev.ImageHash})
+	if err != nil {
+		return err
+	}
+
+	reverted, err := h.ItemRepo.EditItem(ctx, domain.Item{
+		ID:          item.ID,
+		Name:        rev.Name,
+		CategoryID:  rev.CategoryID,
+		UserID:      item.UserID,
+		Price:       rev.Price,
+		Description: rev.Description,
+		ImageHash:   rev.ImageHash,
+		Status:      item.Status,
+	}, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	if err := h.flagDuplicates(ctx, reverted.ID, duplicates); err != nil {
+		return err
+	}
+
+	h.notifyPriceDrop(ctx, c, reverted, item.Price)
+
+	return c.JSON(http.StatusOK, revertItemResponse{ID: int64(reverted.ID)})
+}
+
+func (h *Handler) getItemForRevisions(c echo.Context) (domain.Item, int64, error) {
+	itemID, err := strconv.ParseInt(c.Param("itemID"), 10, 32)
+	if err != nil {
+		return domain.Item{}, 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid itemID")
+	}
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return domain.Item{}, 0, echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	item, err := h.ItemRepo.GetItem(c.Request().Context(), int32(itemID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Item{}, 0, echo.NewHTTPError(http.StatusNotFound, "Item not found")
+		}
+		return domain.Item{}, 0, echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return item, userID, nil
+}
+
+func (h *Handler) getRevision(c echo.Context, itemID int32) (domain.ItemRevision, error) {
+	revisionID, err := strconv.ParseInt(c.Param("revisionID"), 10, 64)
+	if err != nil {
+		return domain.ItemRevision{}, echo.NewHTTPError(http.StatusBadRequest, "Invalid revisionID")
+	}
+
+	rev, err := h.ItemRepo.GetItemRevision(c.Request().Context(), itemID, revisionID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.ItemRevision{}, echo.NewHTTPError(http.StatusNotFound, "Revision not found")
+		}
+		return domain.ItemRevision{}, echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return rev, nil
+}
+
+// canViewRevisions allows the seller, moderators and the buyer of a sold item.
+func (h *Handler) canViewRevisions(c echo.Context, userID int64, item domain.Item) (bool, error) {
+	if item.UserID == userID || hasRole(c, domain.RoleModerator) {
+		return true, nil
+	}
+
+	purchase, err := h.PurchaseRepo.GetPurchaseByItemID(c.Request().Context(), item.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return purchase.BuyerID == userID, nil
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// Failed logins allowed before each further one has to wait, doubling the
+	// wait every time. An IP gets more as several users may share it.
+	loginFreeAttempts   = getEnvInt("LOGIN_FREE_ATTEMPTS", 5)
+	loginIPFreeAttempts = getEnvInt("LOGIN_IP_FREE_ATTEMPTS", 20)
+	loginBackoffBase    = getEnvDuration("LOGIN_BACKOFF_BASE", time.Second)
+	loginMaxBackoff     = getEnvDuration("LOGIN_MAX_BACKOFF", 15*time.Minute)
+)
+
+// Failed logins older than this are forgotten.
+const loginFailureWindow = 24 * time.Hour
+
+// dummyPasswordHash is compared against when the account doesn't exist, so
+// the response takes as long as for a wrong password.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// checkPassword compares password with the hash of user, returning
+// bcrypt.ErrMismatchedHashAndPassword for wrong passwords, unknown users and
+// users created by an OIDC login, who have no password, alike.
+func checkPassword(user domain.User, password string) error {
+	if user.ID == 0 || user.Password == "" {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return bcrypt.ErrMismatchedHashAndPassword
+	}
+	return bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+}
+
+// loginBackoff returns how long the account and the IP of attempt, recorded
+// as attemptID, have to wait before trying to log in again. Only earlier
+// attempts count, including the ones still checking their password.
+func (h *Handler) loginBackoff(ctx context.Context, attempt domain.LoginAttempt, attemptID int64) (time.Duration, error) {
+	account, err := h.LoginAttemptRepo.GetAccountFailures(ctx, attempt.UserID, attempt.Login, attemptID, loginFailureWindow)
+	if err != nil {
+		return 0, err
+	}
+	ip, err := h.LoginAttemptRepo.GetIPFailures(ctx, attempt.IP, attemptID, loginFailureWindow)
+	if err != nil {
+		return 0, err
+	}
+
+	wait := remainingBackoff(account, loginFreeAttempts)
+	if ipWait := remainingBackoff(ip, loginIPFreeAttempts); ipWait > wait {
+		wait = ipWait
+	}
+	return wait, nil
+}
+
+// remainingBackoff is how much of the wait earned by failures is left.
+func remainingBackoff(failures domain.LoginFailures, free int) time.Duration {
+	if failures.Count < free {
+		return 0
+	}
+
+	backoff := loginMaxBackoff
+	if n := failures.Count - free; n < 30 {
+		if d := loginBackoffBase << n; d < backoff {
+			backoff = d
+		}
+	}
+	return time.Until(parseDBTime(failures.LastAt).Add(backoff))
+}
@@ -0,0 +1,346 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/oidc"
+)
+
+const (
+	oidcStateTTL = 10 * time.Minute
+	// Names of users created by an OIDC login are derived from the
+	// provider's username and made unique with a numeric suffix.
+	oidcMaxNameLength   = 40
+	oidcNameAttempts    = 5
+	oidcNameSuffixRange = 10000
+)
+
+var oidcNameInvalidChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+type oidcAuthorizationResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+	ExpiresIn        int64  `json:"expires_in"`
+}
+
+type oidcCallbackRequest struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+type identityResponse struct {
+	ID        int64  `json:"id"`
+	Issuer    string `json:"issuer"`
+	Subject   string `json:"subject"`
+	Email     string `json:"email"`
+	CreatedAt string `json:"created_at"`
+}
+
+// OIDCLogin starts a login with the OpenID Connect provider. The client sends
+// the browser to the returned URL, and the provider sends it back to the
+// redirect URL with a code and the state to be posted to OIDCCallback.
+func (h *Handler) OIDCLogin(c echo.Context) error {
+	if h.OIDC == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "OIDC login is not configured")
+	}
+	return h.startOIDC(c, 0)
+}
+
+// OIDCCallback completes a login with the provider. The user linked to the
+// provider's subject is logged in, and a user is created for a subject seen
+// for the first time. Existing users are never matched by email, as that
+// would let anyone controlling the address at the provider take the account
+// over; they link the identity from their account instead.
+func (h *Handler) OIDCCallback(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if h.OIDC == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "OIDC login is not configured")
+	}
+	claims, err := h.finishOIDC(c, 0)
+	if err != nil {
+		return err
+	}
+
+	var user domain.User
+	identity, err := h.IdentityRepo.GetIdentity(ctx, h.OIDC.Issuer(), claims.Subject)
+	switch {
+	case err == nil:
+		user, err = h.UserRepo.GetUser(ctx, identity.UserID)
+	case errors.Is(err, sql.ErrNoRows):
+		user, err = h.addOIDCUser(ctx, claims)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	enabled, err := h.twoFactorEnabled(ctx, user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if enabled {
+		return h.startTwoFactorChallenge(c, user)
+	}
+
+	accessToken, refreshToken, err := h.startSession(c, user)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, loginResponse{
+		ID:           user.ID,
+		Name:         user.Name,
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(accessTokenTTL / time.Second),
+	})
+}
+
+// GetIdentities lists the provider accounts linked to the current user.
+func (h *Handler) GetIdentities(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	identities, err := h.IdentityRepo.GetIdentities(c.Request().Context(), userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	res := make([]identityResponse, 0, len(identities))
+	for _, identity := range identities {
+		res = append(res, newIdentityResponse(identity))
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// LinkIdentity starts linking a provider account to the current user, like
+// OIDCLogin. The state is bound to the user, so it can only be completed by
+// LinkIdentityCallback with the same user's token.
+func (h *Handler) LinkIdentity(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+	if h.OIDC == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "OIDC login is not configured")
+	}
+	return h.startOIDC(c, userID)
+}
+
+// LinkIdentityCallback links the provider account the browser came back from
+// to the current user.
+func (h *Handler) LinkIdentityCallback(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+	if h.OIDC == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "OIDC login is not configured")
+	}
+	claims, err := h.finishOIDC(c, userID)
+	if err != nil {
+		return err
+	}
+
+	identity, err := h.IdentityRepo.GetIdentity(ctx, h.OIDC.Issuer(), claims.Subject)
+	if err == nil {
+		if identity.UserID != userID {
+			return echo.NewHTTPError(http.StatusConflict, "this account is already linked to another user")
+		}
+		return c.JSON(http.StatusOK, newIdentityResponse(identity))
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	identity = domain.Identity{
+		UserID:  userID,
+		Issuer:  h.OIDC.Issuer(),
+		Subject: claims.Subject,
+		Email:   verifiedEmail(claims),
+	}
+	if identity.ID, err = h.IdentityRepo.AddIdentity(ctx, identity); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if identity, err = h.IdentityRepo.GetIdentity(ctx, identity.Issuer, identity.Subject); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusCreated, newIdentityResponse(identity))
+}
+
+// UnlinkIdentity removes a provider account from the current user. Users
+// created by an OIDC login have no password, so they can't unlink their last
+// identity until they set one with ChangePassword.
+func (h *Handler) UnlinkIdentity(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	identityID, err := strconv.ParseInt(c.Param("identityID"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid identityID")
+	}
+
+	user, err := h.UserRepo.GetUser(ctx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if user.Password == "" {
+		identities, err := h.IdentityRepo.GetIdentities(ctx, userID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+		if len(identities) == 1 && identities[0].ID == identityID {
+			return echo.NewHTTPError(http.StatusConflict, "set a password before unlinking your only login")
+		}
+	}
+
+	if err := h.IdentityRepo.DeleteIdentity(ctx, userID, identityID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "Identity not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// startOIDC stores a new state with its nonce and PKCE verifier and answers
+// with the provider URL carrying them.
+func (h *Handler) startOIDC(c echo.Context, userID int64) error {
+	ctx := c.Request().Context()
+
+	var state, nonce, verifier string
+	for _, s := range []*string{&state, &nonce, &verifier} {
+		v, err := oidc.RandomString()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+		*s = v
+	}
+
+	url, err := h.OIDC.AuthCodeURL(ctx, state, nonce, oidc.Challenge(verifier))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadGateway, err.Error())
+	}
+	if err := h.IdentityRepo.AddOIDCState(ctx, domain.OIDCState{Nonce: nonce, CodeVerifier: verifier, UserID: userID}, hashToken(state), oidcStateTTL); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, oidcAuthorizationResponse{
+		AuthorizationURL: url,
+		ExpiresIn:        int64(oidcStateTTL / time.Second),
+	})
+}
+
+// finishOIDC uses up the state posted back by the client and exchanges the
+// code for the verified claims of the ID token.
+func (h *Handler) finishOIDC(c echo.Context, userID int64) (oidc.Claims, error) {
+	ctx := c.Request().Context()
+
+	req := new(oidcCallbackRequest)
+	if err := c.Bind(req); err != nil {
+		return oidc.Claims{}, echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	if req.Code == "" || req.State == "" {
+		return oidc.Claims{}, echo.NewHTTPError(http.StatusBadRequest, "code and state are required")
+	}
+
+	state, err := h.IdentityRepo.UseOIDCState(ctx, hashToken(req.State), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return oidc.Claims{}, echo.NewHTTPError(http.StatusUnauthorized, "Invalid or expired state, start again")
+		}
+		return oidc.Claims{}, echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	claims, err := h.OIDC.Exchange(ctx, req.Code, state.CodeVerifier, state.Nonce)
+	if err != nil {
+		c.Logger().Warnf("OIDC code exchange failed: %s", err)
+		return oidc.Claims{}, echo.NewHTTPError(http.StatusUnauthorized, "Login with the provider failed")
+	}
+	return claims, nil
+}
+
+// addOIDCUser creates a user without a password for a new identity.
+func (h *Handler) addOIDCUser(ctx context.Context, claims oidc.Claims) (domain.User, error) {
+	base := oidcUserName(claims)
+	identity := domain.Identity{
+		Issuer:  h.OIDC.Issuer(),
+		Subject: claims.Subject,
+		Email:   verifiedEmail(claims),
+	}
+
+	name := base
+	for i := 0; i < oidcNameAttempts; i++ {
+		if _, err := h.UserRepo.GetUserByName(ctx, name); errors.Is(err, sql.ErrNoRows) {
+			user := domain.User{Name: name, Email: identity.Email}
+			id, err := h.IdentityRepo.AddUserWithIdentity(ctx, user, identity)
+			if err != nil {
+				return domain.User{}, err
+			}
+			return h.UserRepo.GetUser(ctx, id)
+		} else if err != nil {
+			return domain.User{}, err
+		}
+
+		n, err := rand.Int(rand.Reader, big.NewInt(oidcNameSuffixRange))
+		if err != nil {
+			return domain.User{}, err
+		}
+		name = fmt.Sprintf("%s-%04d", base, n.Int64())
+	}
+	return domain.User{}, fmt.Errorf("no free name for %q", base)
+}
+
+// oidcUserName derives a user name from the provider's username or the
+// local part of the email address.
+func oidcUserName(claims oidc.Claims) string {
+	name := claims.PreferredUsername
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+	name = strings.Trim(oidcNameInvalidChars.ReplaceAllString(name, "-"), "-")
+	if len(name) > oidcMaxNameLength {
+		name = name[:oidcMaxNameLength]
+	}
+	if name == "" {
+		name = "user"
+	}
+	return name
+}
+
+// verifiedEmail returns the email address of claims if the provider verified it.
+func verifiedEmail(claims oidc.Claims) string {
+	if !claims.EmailVerified {
+		return ""
+	}
+	return claims.Email
+}
+
+func newIdentityResponse(identity domain.Identity) identityResponse {
+	return identityResponse{
+		ID:        identity.ID,
+		Issuer:    identity.Issuer,
+		Subject:   identity.Subject,
+		Email:     identity.Email,
+		CreatedAt: identity.CreatedAt,
+	}
+}
@@ -0,0 +1,376 @@
+package handler
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/db"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/jwtkey"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/oidc"
+)
+
+const (
+	testClientID    = "test-client"
+	testRedirectURL = "http://localhost:3000/oidc/callback"
+	testKeyID       = "test-key"
+)
+
+// grant is an authorization code issued by fakeProvider and what it was
+// issued for.
+type grant struct {
+	subject   string
+	nonce     string
+	challenge string
+}
+
+// fakeProvider is a minimal OpenID Connect provider. Codes are issued by
+// authorize in place of a browser login, and the token endpoint checks the
+// PKCE verifier against the challenge the code was issued for.
+type fakeProvider struct {
+	server *httptest.Server
+	key    ed25519.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]grant
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeProvider{key: key, codes: make(map[string]grant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.server.URL,
+			"authorization_endpoint": f.server.URL + "/authorize",
+			"token_endpoint":         f.server.URL + "/token",
+			"jwks_uri":               f.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "OKP",
+				"crv": "Ed25519",
+				"kid": testKeyID,
+				"use": "sig",
+				"x":   base64.RawURLEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", f.token)
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeProvider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	g, ok := f.codes[r.PostForm.Get("code")]
+	delete(f.codes, r.PostForm.Get("code"))
+	f.mu.Unlock()
+
+	fail := func(status int, code string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+	switch {
+	case !ok:
+		fail(http.StatusBadRequest, "invalid_grant")
+		return
+	case r.PostForm.Get("client_id") != testClientID || r.PostForm.Get("redirect_uri") != testRedirectURL:
+		fail(http.StatusUnauthorized, "invalid_client")
+		return
+	case oidc.Challenge(r.PostForm.Get("code_verifier")) != g.challenge:
+		fail(http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+		"iss":                f.server.URL,
+		"aud":                testClientID,
+		"sub":                g.subject,
+		"nonce":              g.nonce,
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Minute).Unix(),
+		"email":              g.subject + "@example.com",
+		"email_verified":     true,
+		"preferred_username": g.subject,
+	})
+	token.Header["kid"] = testKeyID
+	signed, err := token.SignedString(f.key)
+	if err != nil {
+		fail(http.StatusInternalServerError, "server_error")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "unused", "token_type": "Bearer", "id_token": signed})
+}
+
+// authorize stands in for the browser login at the provider: it issues a code
+// for subject to the authorization URL and returns the code and state the
+// browser would bring back.
+func (f *fakeProvider) authorize(t *testing.T, authorizationURL string, subject string) (string, string) {
+	t.Helper()
+	u, err := url.Parse(authorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("client_id") != testClientID || q.Get("redirect_uri") != testRedirectURL || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("authorization URL %s: unexpected client, redirect or challenge method", authorizationURL)
+	}
+	code, err := oidc.RandomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	f.codes[code] = grant{subject: subject, nonce: q.Get("nonce"), challenge: q.Get("code_challenge")}
+	f.mu.Unlock()
+	return code, q.Get("state")
+}
+
+// setNonce changes the nonce the ID token of code will carry.
+func (f *fakeProvider) setNonce(code string, nonce string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	g := f.codes[code]
+	g.nonce = nonce
+	f.codes[code] = g
+}
+
+// newTestDB returns an empty database with the current schema.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	sqlDB, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "mercari.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	schema, err := os.ReadFile(filepath.Join("..", "sql", "01_schema.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sqlDB.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	return sqlDB
+}
+
+func newOIDCTestHandler(t *testing.T) (*Handler, *fakeProvider) {
+	t.Helper()
+	sqlDB := newTestDB(t)
+
+	keys, err := jwtkey.NewManager(jwtkey.Config{Algorithm: jwtkey.AlgEdDSA, Grace: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := newFakeProvider(t)
+	return &Handler{
+		DB:            sqlDB,
+		UserRepo:      db.NewUserRepository(sqlDB),
+		SessionRepo:   db.NewSessionRepository(sqlDB),
+		TwoFactorRepo: db.NewTwoFactorRepository(sqlDB),
+		IdentityRepo:  db.NewIdentityRepository(sqlDB),
+		Keys:          keys,
+		OIDC: oidc.NewProvider(oidc.Config{
+			Issuer:      provider.server.URL,
+			ClientID:    testClientID,
+			RedirectURL: testRedirectURL,
+			Scopes:      []string{"openid", "email", "profile"},
+		}, provider.server.Client()),
+	}, provider
+}
+
+// call runs handler with a JSON body, as userID when it isn't 0, and returns
+// the status and body of the response.
+func call(t *testing.T, handler echo.HandlerFunc, body interface{}, userID int64) (int, []byte) {
+	t.Helper()
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(b)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	if userID != 0 {
+		c.Set("user", &jwt.Token{Claims: &JwtCustomClaims{UserID: userID}})
+	}
+
+	if err := handler(c); err != nil {
+		var he *echo.HTTPError
+		if !errors.As(err, &he) {
+			t.Fatalf("handler returned %v", err)
+		}
+		return he.Code, nil
+	}
+	return rec.Code, rec.Body.Bytes()
+}
+
+// startLogin calls start and returns the authorization URL it answered with.
+func startLogin(t *testing.T, start echo.HandlerFunc, userID int64) string {
+	t.Helper()
+	status, body := call(t, start, nil, userID)
+	if status != http.StatusOK {
+		t.Fatalf("start: status %d", status)
+	}
+	var res oidcAuthorizationResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		t.Fatal(err)
+	}
+	return res.AuthorizationURL
+}
+
+// oidcLogin logs in as subject at the provider and returns the logged-in user.
+func oidcLogin(t *testing.T, h *Handler, provider *fakeProvider, subject string) loginResponse {
+	t.Helper()
+	code, state := provider.authorize(t, startLogin(t, h.OIDCLogin, 0), subject)
+	status, body := call(t, h.OIDCCallback, oidcCallbackRequest{Code: code, State: state}, 0)
+	if status != http.StatusOK {
+		t.Fatalf("OIDCCallback: status %d", status)
+	}
+	var res loginResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestOIDCLoginCreatesUser(t *testing.T) {
+	h, provider := newOIDCTestHandler(t)
+
+	first := oidcLogin(t, h, provider, "alice")
+	if first.Name != "alice" || first.Token == "" || first.RefreshToken == "" {
+		t.Errorf("first login = %+v, want a session for a new user alice", first)
+	}
+	user, err := h.UserRepo.GetUser(context.Background(), first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Password != "" || user.Email != "alice@example.com" {
+		t.Errorf("new user = %+v, want no password and the verified email", user)
+	}
+
+	// The subject is linked, so logging in again finds the same user
+	if again := oidcLogin(t, h, provider, "alice"); again.ID != first.ID {
+		t.Errorf("second login as user %d, want %d", again.ID, first.ID)
+	}
+	// A new subject with a taken name gets a name of its own
+	other := oidcLogin(t, h, provider, "ALICE")
+	if other.ID == first.ID || !strings.HasPrefix(other.Name, "ALICE-") {
+		t.Errorf("login as another subject = %+v, want a new user with a suffixed name", other)
+	}
+}
+
+func TestOIDCCallbackRejectsBadState(t *testing.T) {
+	h, provider := newOIDCTestHandler(t)
+	code, state := provider.authorize(t, startLogin(t, h.OIDCLogin, 0), "alice")
+
+	if status, _ := call(t, h.OIDCCallback, oidcCallbackRequest{Code: code, State: "forged"}, 0); status != http.StatusUnauthorized {
+		t.Errorf("unknown state: status %d, want 401", status)
+	}
+	if status, _ := call(t, h.OIDCCallback, oidcCallbackRequest{Code: code, State: state}, 0); status != http.StatusOK {
+		t.Fatalf("valid state: status %d, want 200", status)
+	}
+	// States are single-use, so a replayed callback is refused
+	if status, _ := call(t, h.OIDCCallback, oidcCallbackRequest{Code: code, State: state}, 0); status != http.StatusUnauthorized {
+		t.Errorf("reused state: status %d, want 401", status)
+	}
+}
+
+// A code issued to one login can't complete another, as its PKCE verifier
+// doesn't match the challenge the code was issued for.
+func TestOIDCCallbackRejectsCodeOfAnotherLogin(t *testing.T) {
+	h, provider := newOIDCTestHandler(t)
+	code, _ := provider.authorize(t, startLogin(t, h.OIDCLogin, 0), "victim")
+	_, state := provider.authorize(t, startLogin(t, h.OIDCLogin, 0), "attacker")
+
+	if status, _ := call(t, h.OIDCCallback, oidcCallbackRequest{Code: code, State: state}, 0); status != http.StatusUnauthorized {
+		t.Errorf("status %d, want 401", status)
+	}
+	if _, err := h.UserRepo.GetUserByName(context.Background(), "victim"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserByName(victim): err = %v, want no user created", err)
+	}
+}
+
+func TestOIDCCallbackRejectsWrongNonce(t *testing.T) {
+	h, provider := newOIDCTestHandler(t)
+	code, state := provider.authorize(t, startLogin(t, h.OIDCLogin, 0), "alice")
+	provider.setNonce(code, "replayed")
+
+	if status, _ := call(t, h.OIDCCallback, oidcCallbackRequest{Code: code, State: state}, 0); status != http.StatusUnauthorized {
+		t.Errorf("status %d, want 401", status)
+	}
+}
+
+func TestLinkIdentity(t *testing.T) {
+	h, provider := newOIDCTestHandler(t)
+	ctx := context.Background()
+	bob, err := h.UserRepo.AddUser(ctx, domain.User{Name: "bob", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	carol, err := h.UserRepo.AddUser(ctx, domain.User{Name: "carol", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A link state is bound to its user and is no login
+	code, state := provider.authorize(t, startLogin(t, h.LinkIdentity, bob), "bob-at-provider")
+	if status, _ := call(t, h.OIDCCallback, oidcCallbackRequest{Code: code, State: state}, 0); status != http.StatusUnauthorized {
+		t.Errorf("link state used to log in: status %d, want 401", status)
+	}
+	code, state = provider.authorize(t, startLogin(t, h.LinkIdentity, bob), "bob-at-provider")
+	if status, _ := call(t, h.LinkIdentityCallback, oidcCallbackRequest{Code: code, State: state}, carol); status != http.StatusUnauthorized {
+		t.Errorf("link state used by another user: status %d, want 401", status)
+	}
+
+	code, state = provider.authorize(t, startLogin(t, h.LinkIdentity, bob), "bob-at-provider")
+	status, body := call(t, h.LinkIdentityCallback, oidcCallbackRequest{Code: code, State: state}, bob)
+	if status != http.StatusCreated {
+		t.Fatalf("LinkIdentityCallback: status %d, want 201", status)
+	}
+	var identity identityResponse
+	if err := json.Unmarshal(body, &identity); err != nil {
+		t.Fatal(err)
+	}
+	if identity.Subject != "bob-at-provider" || identity.Issuer != provider.server.URL {
+		t.Errorf("identity = %+v, want bob-at-provider at the provider", identity)
+	}
+
+	// Logging in with the linked account logs into bob
+	if res := oidcLogin(t, h, provider, "bob-at-provider"); res.ID != bob {
+		t.Errorf("login with the linked account as user %d, want %d", res.ID, bob)
+	}
+	// and the account can't be linked to anyone else
+	code, state = provider.authorize(t, startLogin(t, h.LinkIdentity, carol), "bob-at-provider")
+	if status, _ := call(t, h.LinkIdentityCallback, oidcCallbackRequest{Code: code, State: state}, carol); status != http.StatusConflict {
+		t.Errorf("linking bob's account to carol: status %d, want 409", status)
+	}
+}
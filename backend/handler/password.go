@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	netmail "net/mail"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/mail"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	passwordResetTTL = getEnvDuration("PASSWORD_RESET_TTL", time.Hour)
+	passwordResetURL = getEnv("PASSWORD_RESET_URL", "http://localhost:3000/reset-password")
+	// Reset mails sent per hour to an account, and for an IP to any accounts
+	passwordResetAccountLimit = getEnvInt("PASSWORD_RESET_ACCOUNT_LIMIT", 3)
+	passwordResetIPLimit      = getEnvInt("PASSWORD_RESET_IP_LIMIT", 10)
+)
+
+const passwordResetRequested = "If the account has an email address, a reset link has been sent to it"
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+	// RevokeAPIKeys also revokes every API key of the user.
+	RevokeAPIKeys bool `json:"revoke_api_keys"`
+}
+
+type requestPasswordResetRequest struct {
+	Name string `json:"name"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// ChangePassword sets a new password for the current user and logs out every
+// other session. API keys keep working unless the request asks to revoke them.
+func (h *Handler) ChangePassword(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	req := new(changePasswordRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	user, err := h.UserRepo.GetUser(ctx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	// Users created by an OIDC login have no password to confirm yet
+	if user.Password != "" {
+		if err := checkPassword(user, req.CurrentPassword); err != nil {
+			if err == bcrypt.ErrMismatchedHashAndPassword {
+				return echo.NewHTTPError(http.StatusUnauthorized, "current password is wrong")
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+	}
+	if err := h.PasswordPolicy.Check(req.NewPassword, user.Name); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if err := h.UserRepo.UpdatePassword(ctx, userID, string(hash)); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if err := h.SessionRepo.RevokeUserSessions(ctx, userID, getSessionID(c)); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if req.RevokeAPIKeys {
+		if err := h.APIKeyRepo.RevokeUserAPIKeys(ctx, userID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// RequestPasswordReset mails a single-use reset link to the email address of
+// an account. The response is the same whether or not the account exists or
+// has an address, so it can't be used to find accounts; for the same reason
+// requests over the hourly limits are dropped silently.
+func (h *Handler) RequestPasswordReset(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	req := new(requestPasswordResetRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	if req.Name == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "name is required")
+	}
+
+	user, err := h.UserRepo.GetUserByName(ctx, req.Name)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && user.Email == "") {
+		return c.JSON(http.StatusAccepted, passwordResetRequested)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	// Addresses stored before they were normalized may carry a display name
+	addr, err := netmail.ParseAddress(user.Email)
+	if err != nil {
+		c.Logger().Errorf("user %d has an invalid email address: %s", user.ID, err)
+		return c.JSON(http.StatusAccepted, passwordResetRequested)
+	}
+
+	account, fromIP, err := h.PasswordResetRepo.CountPasswordResets(ctx, user.ID, c.RealIP(), time.Hour)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if account >= passwordResetAccountLimit || fromIP >= passwordResetIPLimit {
+		c.Logger().Warnf("password reset of user %d from %s dropped: too many requests", user.ID, c.RealIP())
+		return c.JSON(http.StatusAccepted, passwordResetRequested)
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if err := h.PasswordResetRepo.AddPasswordReset(ctx, user.ID, c.RealIP(), hashToken(token), passwordResetTTL); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	link := passwordResetURL + "?token=" + url.QueryEscape(token)
+	msg := mail.Message{
+		To:      addr.Address,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen this link within %s to choose a new password:\n\n%s\n\nYour reset token is %s.\nIf you didn't ask for this, ignore this email.",
+			user.Name, passwordResetTTL, link, token),
+	}
+	if err := h.Mailer.Send(ctx, msg); err != nil {
+		// Failing here would tell that the account exists
+		c.Logger().Errorf("failed to send password reset mail to user %d: %s", user.ID, err)
+	}
+
+	return c.JSON(http.StatusAccepted, passwordResetRequested)
+}
+
+// ResetPassword sets a new password with a reset token, logs out every
+// session of the user and revokes their API keys, so nothing created by
+// whoever had the account survives its recovery.
+func (h *Handler) ResetPassword(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	req := new(resetPasswordRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	if req.Token == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "token is required")
+	}
+
+	// The policy refuses passwords resembling the name
+	userID, err := h.PasswordResetRepo.GetPasswordResetUserID(ctx, hashToken(req.Token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid or expired reset token")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	user, err := h.UserRepo.GetUser(ctx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if err := h.PasswordPolicy.Check(req.Password, user.Name); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	userID, err = h.PasswordResetRepo.ResetPassword(ctx, hashToken(req.Token), string(hash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid or expired reset token")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if err := h.SessionRepo.RevokeUserSessions(ctx, userID, 0); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if err := h.APIKeyRepo.RevokeUserAPIKeys(ctx, userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+// patchItemRequest only carries the fields the client wants to change;
+// a nil field keeps the current value.
+type patchItemRequest struct {
+	Name        *string            `json:"name" form:"name"`
+	CategoryID  *int64             `json:"category_id" form:"category_id"`
+	Price       *int64             `json:"price" form:"price"`
+	Description *string            `json:"description" form:"description"`
+	Status      *domain.ItemStatus `json:"status" form:"status"`
+	// Attributes are merged into the current ones; an empty value removes one.
+	// Multipart requests send them as a JSON object in the attributes field.
+	Attributes map[string]string `json:"attributes"`
+}
+
+type patchItemResponse struct {
+	ID int64 `json:"id"`
+}
+
+// PatchItem partially updates an item. It accepts JSON or multipart bodies,
+// keeps the existing image unless a new one is uploaded, and leaves the
+// status untouched unless a transition is explicitly requested.
+func (h *Handler) PatchItem(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	req := new(patchItemRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	itemID, err := strconv.ParseInt(c.Param("itemID"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid item ID")
+	}
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	item, err := h.ItemRepo.GetItem(ctx, int32(itemID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "Item not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if item.UserID != userID {
+		return echo.NewHTTPError(http.StatusForbidden, "User is not the owner of the item")
+	}
+	if item.Status == domain.ItemStatusSoldOut {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "Sold items cannot be edited")
+	}
+
+	oldPrice := item.Price
+
+	// validation of the provided fields only
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" || len(name) > 50 {
+			return echo.NewHTTPError(http.StatusBadRequest, "name must be between 1 and 50 characters")
+		}
+		item.Name = name
+	}
+	if req.Price != nil {
+		if *req.Price <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "price must be greater than 0")
+		}
+		item.Price = *req.Price
+	}
+	if req.Description != nil {
+		if strings.TrimSpace(*req.Description) == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "description must not be empty")
+		}
+		item.Description = *req.Description
+	}
+	if req.CategoryID != nil {
+		if _, err := h.ItemRepo.GetCategory(ctx, *req.CategoryID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return echo.NewHTTPError(http.StatusBadRequest, "Category does not exist")
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+		item.CategoryID = *req.CategoryID
+	}
+	if req.Status != nil && *req.Status != item.Status {
+		if *req.Status == domain.ItemStatusOnSale {
+			return echo.NewHTTPError(http.StatusPreconditionFailed, "use POST /sell to put an item on sale")
+		}
+		if !isAllowedStatusTransition(item.Status, *req.Status) {
+			return echo.NewHTTPError(http.StatusPreconditionFailed, "invalid status transition")
+		}
+		item.Status = *req.Status
+	}
+
+	// Uploading images replaces all of them; without any the current ones are kept
+	var hashes []string
+	var duplicates []domain.SimilarItem
+	multipart := strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm)
+	if multipart {
+		if req.Attributes, err = readAttributes(c); err != nil {
+			return err
+		}
+	}
+
+	// Attributes are revalidated when the category changes, as its schema may differ
+	var attrs map[string]string
+	if len(req.Attributes) > 0 || req.CategoryID != nil {
+		if attrs, err = h.AttributeRepo.GetItemAttributes(ctx, item.ID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+		for name, value := range req.Attributes {
+			attrs[name] = value
+		}
+		if attrs, err = h.validateAttributes(ctx, item.CategoryID, attrs); err != nil {
+			return err
+		}
+	}
+
+	if multipart {
+		images, err := h.readImageFiles(c)
+		if err != nil {
+			return err
+		}
+		if hashes, err = h.putImages(ctx, images); err != nil {
+			return err
+		}
+		if len(hashes) > 0 {
+			oldHashes, err := h.ItemRepo.GetItemImageHashes(ctx, item.ID)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, err)
+			}
+			if duplicates, err = h.checkNewImages(ctx, oldHashes, hashes); err != nil {
+				return err
+			}
+			item.ImageHash = hashes[0]
+		}
+	}
+
+	updated, err := h.ItemRepo.EditItem(ctx, item, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	if len(hashes) > 0 {
+		if err := h.ItemRepo.SetItemImages(ctx, updated.ID, hashes, userID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+	}
+
+	if attrs != nil {
+		if err := h.AttributeRepo.SetItemAttributes(ctx, updated.ID, attrs); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+	}
+
+	if err := h.flagDuplicates(ctx, updated.ID, duplicates); err != nil {
+		return err
+	}
+
+	h.notifyPriceDrop(ctx, c, updated, oldPrice)
+
+	return c.JSON(http.StatusOK, patchItemResponse{ID: int64(updated.ID)})
+}
+
+// isAllowedStatusTransition only lets a seller take an item off sale. Items
+// are put on sale by Sell, so its checks and side effects can't be skipped,
+// and sold items are final.
+func isAllowedStatusTransition(from domain.ItemStatus, to domain.ItemStatus) bool {
+	return from == domain.ItemStatusOnSale && to == domain.ItemStatusInitial
+}
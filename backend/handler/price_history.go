@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+type getPriceHistoryResponse struct {
+	Price     int64  `json:"price"`
+	ChangedAt string `json:"changed_at"`
+}
+
+type watchItemRequest struct {
+	Threshold int64 `json:"threshold"`
+}
+
+type getNotificationsResponse struct {
+	ID        int64                   `json:"id"`
+	Type      domain.NotificationType `json:"type"`
+	ItemID    int32                   `json:"item_id"`
+	Message   string                  `json:"message"`
+	IsRead    bool                    `json:"is_read"`
+	CreatedAt string                  `json:"created_at"`
+}
+
+func (h *Handler) GetPriceHistory(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	itemID, err := strconv.ParseInt(c.Param("itemID"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid itemID")
+	}
+
+	if _, err := h.ItemRepo.GetItem(ctx, int32(itemID)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "Item not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	history, err := h.ItemRepo.GetPriceHistory(ctx, int32(itemID))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	res := make([]getPriceHistoryResponse, len(history))
+	for i, entry := range history {
+		res[i] = getPriceHistoryResponse{Price: entry.Price, ChangedAt: entry.CreatedAt}
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+// WatchItem registers (or updates) the caller's price-drop alert threshold for an item.
+func (h *Handler) WatchItem(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	req := new(watchItemRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	if req.Threshold <= 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "threshold must be positive")
+	}
+
+	itemID, err := strconv.ParseInt(c.Param("itemID"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid itemID")
+	}
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	item, err := h.ItemRepo.GetItem(ctx, int32(itemID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "Item not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if item.UserID == userID {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "You cannot watch your own item.")
+	}
+
+	if err := h.PriceWatchRepo.SetPriceWatch(ctx, domain.PriceWatch{
+		UserID:    userID,
+		ItemID:    item.ID,
+		Threshold: req.Threshold,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, "successful")
+}
+
+func (h *Handler) UnwatchItem(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	itemID, err := strconv.ParseInt(c.Param("itemID"), 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid itemID")
+	}
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	if err := h.PriceWatchRepo.DeletePriceWatch(ctx, userID, int32(itemID)); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, "successful")
+}
+
+func (h *Handler) GetNotifications(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	notifications, err := h.NotificationRepo.GetNotifications(ctx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	res := make([]getNotificationsResponse, len(notifications))
+	for i, n := range notifications {
+		res[i] = getNotificationsResponse{
+			ID:        n.ID,
+			Type:      n.Type,
+			ItemID:    n.ItemID,
+			Message:   n.Message,
+			IsRead:    n.IsRead,
+			CreatedAt: n.CreatedAt,
+		}
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+// ReadNotification marks a notification of the current user as read.
+func (h *Handler) ReadNotification(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	notificationID, err := strconv.ParseInt(c.Param("notificationID"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid notificationID")
+	}
+
+	// Other users' notifications are not found, so their IDs can't be probed
+	if err := h.NotificationRepo.MarkNotificationRead(c.Request().Context(), userID, notificationID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "Notification not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ReadAllNotifications marks every notification of the current user as read.
+func (h *Handler) ReadAllNotifications(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	if err := h.NotificationRepo.MarkAllNotificationsRead(c.Request().Context(), userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// notifyPriceDrop alerts every watcher whose threshold was crossed by the price change.
+// The edit itself has already been committed, so failures are logged instead of returned.
+func (h *Handler) notifyPriceDrop(ctx context.Context, c echo.Context, item domain.Item, oldPrice int64) {
+	if item.Price >= oldPrice {
+		return
+	}
+
+	watches, err := h.PriceWatchRepo.GetCrossedPriceWatches(ctx, item.ID, oldPrice, item.Price)
+	if err != nil {
+		c.Logger().Error(err)
+		return
+	}
+
+	for _, watch := range watches {
+		err := h.NotificationRepo.AddNotification(ctx, domain.Notification{
+			UserID:  watch.UserID,
+			Type:    domain.NotificationTypePriceDrop,
+			ItemID:  item.ID,
+			Message: fmt.Sprintf("%s dropped from %d to %d (your alert: %d)", item.Name, oldPrice, item.Price, watch.Threshold),
+		})
+		if err != nil {
+			c.Logger().Error(err)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 500
+	maxLocationLength    = 100
+)
+
+type profileResponse struct {
+	ID          int64          `json:"id"`
+	Name        string         `json:"name"`
+	DisplayName string         `json:"display_name"`
+	Bio         string         `json:"bio"`
+	Location    string         `json:"location"`
+	HasAvatar   bool           `json:"has_avatar"`
+	JoinedAt    string         `json:"joined_at,omitempty"`
+	Rating      ratingResponse `json:"rating"`
+	Followers   int            `json:"followers"`
+	Following   int            `json:"following"`
+}
+
+type updateProfileRequest struct {
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	Location    string `json:"location"`
+}
+
+type storefrontResponse struct {
+	Profile   profileResponse        `json:"profile"`
+	Items     []getUserItemsResponse `json:"items"`
+	SoldCount int                    `json:"sold_count"`
+}
+
+// GetProfile returns the public profile of a user.
+func (h *Handler) GetProfile(c echo.Context) error {
+	profile, err := h.getProfile(c)
+	if err != nil {
+		return err
+	}
+
+	res, err := h.newProfileResponse(c.Request().Context(), profile)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// GetStorefront returns what a buyer sees of a seller: the profile with the
+// rating, the items on sale and how many items the seller has sold.
+func (h *Handler) GetStorefront(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	profile, err := h.getProfile(c)
+	if err != nil {
+		return err
+	}
+
+	items, err := h.ItemRepo.GetItemsByUserID(ctx, profile.UserID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	var onSale []domain.Item
+	sold := 0
+	for _, item := range items {
+		switch item.Status {
+		case domain.ItemStatusOnSale:
+			onSale = append(onSale, item)
+		case domain.ItemStatusSoldOut:
+			sold++
+		}
+	}
+
+	res, err := h.userItemsResponse(ctx, onSale)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	profileRes, err := h.newProfileResponse(ctx, profile)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, storefrontResponse{
+		Profile:   profileRes,
+		Items:     res,
+		SoldCount: sold,
+	})
+}
+
+// UpdateProfile replaces the display name, bio and location of the current
+// user. Empty fields are cleared.
+func (h *Handler) UpdateProfile(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	req := new(updateProfileRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	req.DisplayName = strings.TrimSpace(req.DisplayName)
+	req.Bio = strings.TrimSpace(req.Bio)
+	req.Location = strings.TrimSpace(req.Location)
+	for _, field := range []struct {
+		name  string
+		value string
+		max   int
+	}{
+		{"display_name", req.DisplayName, maxDisplayNameLength},
+		{"bio", req.Bio, maxBioLength},
+		{"location", req.Location, maxLocationLength},
+	} {
+		if utf8.RuneCountInString(field.value) > field.max {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s must be at most %d characters", field.name, field.max))
+		}
+	}
+
+	profile, err := h.UserRepo.GetProfile(ctx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	profile.DisplayName = req.DisplayName
+	profile.Bio = req.Bio
+	profile.Location = req.Location
+	if err := h.UserRepo.UpdateProfile(ctx, profile); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	res, err := h.newProfileResponse(ctx, profile)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// GetAvatar serves the avatar of a user in the size of the size query
+// parameter like item images.
+func (h *Handler) GetAvatar(c echo.Context) error {
+	size, err := parseImageSize(c)
+	if err != nil {
+		return err
+	}
+
+	profile, err := h.getProfile(c)
+	if err != nil {
+		return err
+	}
+	if profile.AvatarHash == "" {
+		return echo.NewHTTPError(http.StatusNotFound, "Avatar not found")
+	}
+
+	key, err := h.ItemRepo.GetImageVariantKey(c.Request().Context(), profile.AvatarHash, size)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "Avatar not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return h.serveImage(c, key)
+}
+
+// SetAvatar replaces the avatar of the current user with the single image
+// uploaded under "image". It is validated and resized like item images.
+func (h *Handler) SetAvatar(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	uploaded, err := h.readImageFiles(c)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(uploaded) == 0:
+		return uploadError(reasonMissingImage, "image is required")
+	case len(uploaded) > 1:
+		return uploadError(reasonTooManyImages, "an avatar is a single image")
+	}
+
+	hashes, err := h.putImages(ctx, uploaded)
+	if err != nil {
+		return err
+	}
+	if err := h.UserRepo.SetAvatar(ctx, userID, hashes[0]); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	profile, err := h.UserRepo.GetProfile(ctx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	res, err := h.newProfileResponse(ctx, profile)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// DeleteAvatar removes the avatar of the current user.
+func (h *Handler) DeleteAvatar(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	if err := h.UserRepo.SetAvatar(c.Request().Context(), userID, ""); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// getProfile loads the profile of the user in the request path.
+func (h *Handler) getProfile(c echo.Context) (domain.Profile, error) {
+	userID, err := strconv.ParseInt(c.Param("userID"), 10, 64)
+	if err != nil {
+		return domain.Profile{}, echo.NewHTTPError(http.StatusBadRequest, "Invalid userID")
+	}
+
+	profile, err := h.UserRepo.GetProfile(c.Request().Context(), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Profile{}, echo.NewHTTPError(http.StatusNotFound, "User not found")
+		}
+		return domain.Profile{}, echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return profile, nil
+}
+
+// userItemsResponse lists items with their category names. Items of unknown
+// categories are left out.
+func (h *Handler) userItemsResponse(ctx context.Context, items []domain.Item) ([]getUserItemsResponse, error) {
+	cats, err := h.ItemRepo.GetCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int64]string, len(cats))
+	for _, cat := range cats {
+		names[cat.ID] = cat.Name
+	}
+
+	res := make([]getUserItemsResponse, 0, len(items))
+	for _, item := range items {
+		name, ok := names[item.CategoryID]
+		if !ok {
+			continue
+		}
+		res = append(res, getUserItemsResponse{ID: item.ID, Name: item.Name, Price: item.Price, CategoryName: name, Placeholder: item.Placeholder})
+	}
+	return res, nil
+}
+
+// newProfileResponse adds the rating a user received from reviews and the
+// follower counts to the profile.
+func (h *Handler) newProfileResponse(ctx context.Context, profile domain.Profile) (profileResponse, error) {
+	rating, err := h.ReviewRepo.GetRatingSummary(ctx, profile.UserID)
+	if err != nil {
+		return profileResponse{}, err
+	}
+	counts, err := h.FollowRepo.GetFollowCounts(ctx, profile.UserID)
+	if err != nil {
+		return profileResponse{}, err
+	}
+
+	displayName := profile.DisplayName
+	if displayName == "" {
+		displayName = profile.Name
+	}
+	return profileResponse{
+		ID:          profile.UserID,
+		Name:        profile.Name,
+		DisplayName: displayName,
+		Bio:         profile.Bio,
+		Location:    profile.Location,
+		HasAvatar:   profile.AvatarHash != "",
+		JoinedAt:    profile.JoinedAt,
+		Rating:      newRatingResponse(rating),
+		Followers:   counts.Followers,
+		Following:   counts.Following,
+	}, nil
+}
@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/db"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+const maxReviewCommentLength = 1000
+
+type purchaseResponse struct {
+	ID        int64               `json:"id"`
+	ItemID    int32               `json:"item_id"`
+	BuyerID   int64               `json:"buyer_id"`
+	SellerID  int64               `json:"seller_id"`
+	Price     int64               `json:"price"`
+	Role      domain.ReviewerRole `json:"role"`
+	Reviewed  bool                `json:"reviewed"`
+	CreatedAt string              `json:"created_at"`
+}
+
+type addReviewRequest struct {
+	Rating  int    `json:"rating"`
+	Comment string `json:"comment"`
+}
+
+type reviewResponse struct {
+	ID           int64               `json:"id"`
+	PurchaseID   int64               `json:"purchase_id"`
+	ItemID       int32               `json:"item_id"`
+	ReviewerID   int64               `json:"reviewer_id"`
+	ReviewerName string              `json:"reviewer_name"`
+	RevieweeID   int64               `json:"reviewee_id"`
+	Role         domain.ReviewerRole `json:"role"`
+	Rating       int                 `json:"rating"`
+	Comment      string              `json:"comment"`
+	CreatedAt    string              `json:"created_at"`
+}
+
+type ratingResponse struct {
+	Average float64 `json:"average"`
+	Count   int     `json:"count"`
+}
+
+// GetPurchases lists the purchases the current user bought or sold in, with
+// the side the user was on and whether the user reviewed it yet.
+func (h *Handler) GetPurchases(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	purchases, err := h.PurchaseRepo.GetUserPurchases(ctx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	reviewed, err := h.ReviewRepo.GetReviewedPurchaseIDs(ctx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	res := make([]purchaseResponse, 0, len(purchases))
+	for _, p := range purchases {
+		role := domain.ReviewerBuyer
+		if p.SellerID == userID {
+			role = domain.ReviewerSeller
+		}
+		res = append(res, purchaseResponse{
+			ID:        p.ID,
+			ItemID:    p.ItemID,
+			BuyerID:   p.BuyerID,
+			SellerID:  p.SellerID,
+			Price:     p.Price,
+			Role:      role,
+			Reviewed:  reviewed[p.ID],
+			CreatedAt: p.CreatedAt,
+		})
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// AddReview rates the other side of a purchase from 1 to 5 with an optional
+// comment. Only the buyer and the seller may review a purchase, once each.
+func (h *Handler) AddReview(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	req := new(addReviewRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	if req.Rating < domain.MinRating || req.Rating > domain.MaxRating {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("rating must be %d to %d", domain.MinRating, domain.MaxRating))
+	}
+	req.Comment = strings.TrimSpace(req.Comment)
+	if utf8.RuneCountInString(req.Comment) > maxReviewCommentLength {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("comment must be at most %d characters", maxReviewCommentLength))
+	}
+
+	purchase, err := h.getPurchase(c)
+	if err != nil {
+		return err
+	}
+
+	review := domain.Review{
+		PurchaseID: purchase.ID,
+		ReviewerID: userID,
+		Rating:     req.Rating,
+		Comment:    req.Comment,
+	}
+	switch userID {
+	case purchase.BuyerID:
+		review.Role = domain.ReviewerBuyer
+		review.RevieweeID = purchase.SellerID
+	case purchase.SellerID:
+		review.Role = domain.ReviewerSeller
+		review.RevieweeID = purchase.BuyerID
+	default:
+		return echo.NewHTTPError(http.StatusForbidden, "You were not part of this purchase")
+	}
+
+	review, err = h.ReviewRepo.AddReview(ctx, review)
+	if err != nil {
+		if errors.Is(err, db.ErrAlreadyReviewed) {
+			return echo.NewHTTPError(http.StatusConflict, "You already reviewed this purchase")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusCreated, newReviewResponse(review))
+}
+
+// GetPurchaseReviews lists the reviews of a purchase to its buyer and seller.
+func (h *Handler) GetPurchaseReviews(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	purchase, err := h.getPurchase(c)
+	if err != nil {
+		return err
+	}
+	if userID != purchase.BuyerID && userID != purchase.SellerID {
+		return echo.NewHTTPError(http.StatusForbidden, "You were not part of this purchase")
+	}
+
+	reviews, err := h.ReviewRepo.GetReviewsByPurchase(c.Request().Context(), purchase.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, newReviewsResponse(reviews))
+}
+
+// GetUserReviews lists the reviews a user received, newest first. The as
+// query parameter narrows them to the ones received as a "seller" or "buyer".
+func (h *Handler) GetUserReviews(c echo.Context) error {
+	profile, err := h.getProfile(c)
+	if err != nil {
+		return err
+	}
+
+	var role domain.ReviewerRole
+	switch c.QueryParam("as") {
+	case "":
+	case "seller":
+		role = domain.ReviewerBuyer
+	case "buyer":
+		role = domain.ReviewerSeller
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "as must be seller or buyer")
+	}
+
+	reviews, err := h.ReviewRepo.GetReviewsOfUser(c.Request().Context(), profile.UserID, role)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, newReviewsResponse(reviews))
+}
+
+// getPurchase loads the purchase in the request path.
+func (h *Handler) getPurchase(c echo.Context) (domain.Purchase, error) {
+	purchaseID, err := strconv.ParseInt(c.Param("purchaseID"), 10, 64)
+	if err != nil {
+		return domain.Purchase{}, echo.NewHTTPError(http.StatusBadRequest, "Invalid purchaseID")
+	}
+
+	purchase, err := h.PurchaseRepo.GetPurchase(c.Request().Context(), purchaseID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Purchase{}, echo.NewHTTPError(http.StatusNotFound, "Purchase not found")
+		}
+		return domain.Purchase{}, echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return purchase, nil
+}
+
+func newReviewResponse(review domain.Review) reviewResponse {
+	return reviewResponse{
+		ID:           review.ID,
+		PurchaseID:   review.PurchaseID,
+		ItemID:       review.ItemID,
+		ReviewerID:   review.ReviewerID,
+		ReviewerName: review.ReviewerName,
+		RevieweeID:   review.RevieweeID,
+		Role:         review.Role,
+		Rating:       review.Rating,
+		Comment:      review.Comment,
+		CreatedAt:    review.CreatedAt,
+	}
+}
+
+func newReviewsResponse(reviews []domain.Review) []reviewResponse {
+	res := make([]reviewResponse, 0, len(reviews))
+	for _, review := range reviews {
+		res = append(res, newReviewResponse(review))
+	}
+	return res
+}
+
+// newRatingResponse rounds the average to one decimal place.
+func newRatingResponse(summary domain.RatingSummary) ratingResponse {
+	return ratingResponse{
+		Average: math.Round(summary.Average*10) / 10,
+		Count:   summary.Count,
+	}
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+type getSessionsResponse struct {
+	ID         int64  `json:"id"`
+	UserAgent  string `json:"user_agent"`
+	IP         string `json:"ip"`
+	CreatedAt  string `json:"created_at"`
+	LastSeenAt string `json:"last_seen_at"`
+	Current    bool   `json:"current"`
+}
+
+// GetSessions lists the devices the current user is logged in on.
+func (h *Handler) GetSessions(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	sessions, err := h.SessionRepo.GetSessions(c.Request().Context(), userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	currentID := getSessionID(c)
+	res := make([]getSessionsResponse, 0, len(sessions))
+	for _, s := range sessions {
+		res = append(res, getSessionsResponse{
+			ID:         s.ID,
+			UserAgent:  s.UserAgent,
+			IP:         s.IP,
+			CreatedAt:  s.CreatedAt,
+			LastSeenAt: s.LastSeenAt,
+			Current:    s.ID == currentID,
+		})
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// RevokeSession logs one of the current user's devices out. Its access token
+// is rejected from the next request on.
+func (h *Handler) RevokeSession(c echo.Context) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	sessionID, err := strconv.ParseInt(c.Param("sessionID"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid sessionID")
+	}
+
+	if err := h.SessionRepo.RevokeSession(c.Request().Context(), userID, sessionID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "Session not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// getSessionID returns the session of the request's access token, or 0.
+func getSessionID(c echo.Context) int64 {
+	token, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return 0
+	}
+	claims, ok := token.Claims.(*JwtCustomClaims)
+	if !ok {
+		return 0
+	}
+	return claims.SessionID
+}
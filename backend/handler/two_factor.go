@@ -0,0 +1,463 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	totpIssuer = getEnv("TOTP_ISSUER", "Mercari Build")
+	// Top-ups and purchases of at least this amount need a code in
+	// X-OTP-Code from users with two-factor authentication. 0 turns it off.
+	twoFactorStepUpAmount = int64(getEnvInt("TWO_FACTOR_STEP_UP_AMOUNT", 0))
+)
+
+const (
+	twoFactorChallengeTTL = 5 * time.Minute
+	maxTwoFactorAttempts  = 5
+	// Wrong codes in a row, across challenges and every other check, after
+	// which a user's codes are refused for twoFactorLockout
+	maxTwoFactorFailures    = 10
+	twoFactorLockout        = 15 * time.Minute
+	recoveryCodeCount       = 10
+	headerOTPCode           = "X-OTP-Code"
+	recoveryCodeHalfLength  = 5
+	recoveryCodeRandomBytes = 7
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+var errTwoFactorLocked = errors.New("Too many wrong two-factor codes, try again later")
+
+type getTwoFactorResponse struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+type enrollTwoFactorResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type twoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type disableTwoFactorRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type loginChallengeResponse struct {
+	ID                int64  `json:"id"`
+	Name              string `json:"name"`
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int64  `json:"expires_in"`
+}
+
+type loginTwoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+// GetTwoFactor tells whether the current user has two-factor authentication on.
+func (h *Handler) GetTwoFactor(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	secret, err := h.TwoFactorRepo.GetTOTPSecret(ctx, userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if !secret.Confirmed {
+		return c.JSON(http.StatusOK, getTwoFactorResponse{})
+	}
+
+	left, err := h.TwoFactorRepo.CountRecoveryCodes(ctx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, getTwoFactorResponse{Enabled: true, RecoveryCodesLeft: left})
+}
+
+// EnrollTwoFactor generates a secret for an authenticator app. Two-factor
+// authentication only turns on once ConfirmTwoFactor receives a code.
+func (h *Handler) EnrollTwoFactor(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	current, err := h.TwoFactorRepo.GetTOTPSecret(ctx, userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if current.Confirmed {
+		return echo.NewHTTPError(http.StatusConflict, "Two-factor authentication is already on")
+	}
+
+	user, err := h.UserRepo.GetUser(ctx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if err := h.TwoFactorRepo.SetTOTPSecret(ctx, userID, secret); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, enrollTwoFactorResponse{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, user.Name, secret),
+	})
+}
+
+// ConfirmTwoFactor turns two-factor authentication on with a first code from
+// the authenticator app and returns the recovery codes, which are shown only once.
+func (h *Handler) ConfirmTwoFactor(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	req := new(twoFactorCodeRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	secret, err := h.TwoFactorRepo.GetTOTPSecret(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusConflict, "Enroll first")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if secret.Confirmed {
+		return echo.NewHTTPError(http.StatusConflict, "Two-factor authentication is already on")
+	}
+
+	step, ok := totp.Validate(secret.Secret, req.Code, time.Now())
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid code")
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if err := h.TwoFactorRepo.ConfirmTOTPSecret(ctx, userID, step, hashes); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the current user,
+// given a valid code.
+func (h *Handler) RegenerateRecoveryCodes(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	req := new(twoFactorCodeRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	if err := h.checkTwoFactorCode(ctx, userID, req.Code); err != nil {
+		return err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if err := h.TwoFactorRepo.SetRecoveryCodes(ctx, userID, hashes); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTwoFactor turns two-factor authentication off, given the password
+// and a valid code. Users created by an OIDC login have no password and only
+// give the code.
+func (h *Handler) DisableTwoFactor(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	req := new(disableTwoFactorRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	user, err := h.UserRepo.GetUser(ctx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if user.Password != "" {
+		if err := checkPassword(user, req.Password); err != nil {
+			if err == bcrypt.ErrMismatchedHashAndPassword {
+				return echo.NewHTTPError(http.StatusUnauthorized, "password is wrong")
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+	}
+	if err := h.checkTwoFactorCode(ctx, userID, req.Code); err != nil {
+		return err
+	}
+
+	if err := h.TwoFactorRepo.DeleteTOTPSecret(ctx, userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// LoginTwoFactor completes a login of a user with two-factor authentication,
+// exchanging the challenge token and a code or recovery code for tokens.
+func (h *Handler) LoginTwoFactor(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	req := new(loginTwoFactorRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	challenge, err := h.TwoFactorRepo.GetChallenge(ctx, hashToken(req.ChallengeToken))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid or expired challenge, log in again")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if err := h.TwoFactorRepo.AddChallengeAttempt(ctx, challenge.ID, maxTwoFactorAttempts); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid or expired challenge, log in again")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	ok, err := h.verifyTwoFactorCode(ctx, challenge.UserID, req.Code)
+	if err != nil {
+		return twoFactorError(err)
+	}
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid code")
+	}
+
+	if err := h.TwoFactorRepo.UseChallenge(ctx, challenge.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid or expired challenge, log in again")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	user, err := h.UserRepo.GetUser(ctx, challenge.UserID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	accessToken, refreshToken, err := h.startSession(c, user)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, loginResponse{
+		ID:           user.ID,
+		Name:         user.Name,
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(accessTokenTTL / time.Second),
+	})
+}
+
+// twoFactorEnabled reports whether a user has confirmed two-factor authentication.
+func (h *Handler) twoFactorEnabled(ctx context.Context, userID int64) (bool, error) {
+	secret, err := h.TwoFactorRepo.GetTOTPSecret(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return secret.Confirmed, err
+}
+
+// startTwoFactorChallenge answers a login with a right password with a
+// challenge to be completed by LoginTwoFactor.
+func (h *Handler) startTwoFactorChallenge(c echo.Context, user domain.User) error {
+	token, err := newToken()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if err := h.TwoFactorRepo.AddChallenge(c.Request().Context(), user.ID, hashToken(token), twoFactorChallengeTTL); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, loginChallengeResponse{
+		ID:                user.ID,
+		Name:              user.Name,
+		TwoFactorRequired: true,
+		ChallengeToken:    token,
+		ExpiresIn:         int64(twoFactorChallengeTTL / time.Second),
+	})
+}
+
+// requireTwoFactorStepUp asks users with two-factor authentication for a
+// fresh code in X-OTP-Code before moving amount, when it is large enough.
+func (h *Handler) requireTwoFactorStepUp(c echo.Context, userID int64, amount int64) error {
+	if twoFactorStepUpAmount <= 0 || amount < twoFactorStepUpAmount {
+		return nil
+	}
+
+	ctx := c.Request().Context()
+	enabled, err := h.twoFactorEnabled(ctx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if !enabled {
+		return nil
+	}
+
+	code := c.Request().Header.Get(headerOTPCode)
+	if code == "" {
+		return echo.NewHTTPError(http.StatusForbidden, "A two-factor code is required in "+headerOTPCode)
+	}
+	ok, err := h.verifyTwoFactorCode(ctx, userID, code)
+	if err != nil {
+		return twoFactorError(err)
+	}
+	if !ok {
+		return echo.NewHTTPError(http.StatusForbidden, "Invalid two-factor code")
+	}
+	return nil
+}
+
+// checkTwoFactorCode is verifyTwoFactorCode for handlers of users who must
+// have two-factor authentication on.
+func (h *Handler) checkTwoFactorCode(ctx context.Context, userID int64, code string) error {
+	enabled, err := h.twoFactorEnabled(ctx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if !enabled {
+		return echo.NewHTTPError(http.StatusConflict, "Two-factor authentication is off")
+	}
+
+	ok, err := h.verifyTwoFactorCode(ctx, userID, code)
+	if err != nil {
+		return twoFactorError(err)
+	}
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid code")
+	}
+	return nil
+}
+
+// verifyTwoFactorCode checks a code from the authenticator app or, failing
+// that, a recovery code. Either can be used only once. After
+// maxTwoFactorFailures wrong codes in a row it fails with errTwoFactorLocked
+// without looking at the code.
+func (h *Handler) verifyTwoFactorCode(ctx context.Context, userID int64, code string) (bool, error) {
+	secret, err := h.TwoFactorRepo.GetTOTPSecret(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	if !secret.Confirmed {
+		return false, nil
+	}
+
+	if err := h.TwoFactorRepo.AddTwoFactorAttempt(ctx, userID, maxTwoFactorFailures, twoFactorLockout); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, errTwoFactorLocked
+		}
+		return false, err
+	}
+	ok, err := h.useTwoFactorCode(ctx, secret, code)
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, h.TwoFactorRepo.ResetTwoFactorFailures(ctx, userID)
+}
+
+// useTwoFactorCode uses up code if it is valid for secret.
+func (h *Handler) useTwoFactorCode(ctx context.Context, secret domain.TOTPSecret, code string) (bool, error) {
+	userID := secret.UserID
+	if step, ok := totp.Validate(secret.Secret, code, time.Now()); ok {
+		err := h.TwoFactorRepo.UseTOTPStep(ctx, userID, step)
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	err := h.TwoFactorRepo.UseRecoveryCode(ctx, userID, hashToken(normalizeRecoveryCode(code)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// twoFactorError turns an error of verifyTwoFactorCode into a response.
+func twoFactorError(err error) error {
+	if errors.Is(err, errTwoFactorLocked) {
+		return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, err)
+}
+
+// newRecoveryCodes returns fresh recovery codes formatted like "abcde-fghij",
+// along with the hashes to store.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, recoveryCodeRandomBytes)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))[:2*recoveryCodeHalfLength]
+		codes = append(codes, code[:recoveryCodeHalfLength]+"-"+code[recoveryCodeHalfLength:])
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
@@ -0,0 +1,41 @@
+package imageproc
+
+import (
+	"bytes"
+	"image"
+	"math/bits"
+)
+
+// DHash computes the difference hash of an encoded image: the image is shrunk
+// to 9x8 grey pixels and every bit tells whether a pixel is darker than its
+// right neighbour. Re-encoding, resizing and small edits leave most bits
+// unchanged, so similar images have hashes a small Distance apart.
+func DHash(data []byte) (uint64, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, &ValidationError{Reason: ReasonCorruptImage, Message: "image data is corrupt"}
+	}
+
+	small := resize(toNRGBA(src), 9, 8)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if luma(small, x, y) < luma(small, x+1, y) {
+				hash |= 1
+			}
+		}
+	}
+	return hash, nil
+}
+
+// Distance is the number of differing bits between two hashes.
+func Distance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func luma(img *image.NRGBA, x int, y int) int {
+	p := img.Pix[y*img.Stride+x*4:]
+	return 299*int(p[0]) + 587*int(p[1]) + 114*int(p[2])
+}
@@ -0,0 +1,48 @@
+package imageproc
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+)
+
+// DominantColor returns the most common colour of an encoded image as
+// "#rrggbb", for clients to paint while the image loads. Colours are grouped
+// into 4096 buckets and the pixels of the largest bucket are averaged, so a
+// product on a white background comes out white rather than a muddy grey.
+// Fully transparent images have no colour and return "".
+func DominantColor(data []byte) (string, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", &ValidationError{Reason: ReasonCorruptImage, Message: "image data is corrupt"}
+	}
+
+	small := toNRGBA(fit(src, 32))
+
+	type bucket struct {
+		r, g, b, n int
+	}
+	var buckets [4096]bucket
+	best := -1
+	for i := 0; i < len(small.Pix); i += 4 {
+		p := small.Pix[i : i+4]
+		if p[3] < 128 {
+			continue
+		}
+		key := int(p[0]>>4)<<8 | int(p[1]>>4)<<4 | int(p[2]>>4)
+		bk := &buckets[key]
+		bk.r += int(p[0])
+		bk.g += int(p[1])
+		bk.b += int(p[2])
+		bk.n++
+		if best < 0 || bk.n > buckets[best].n {
+			best = key
+		}
+	}
+	if best < 0 {
+		return "", nil
+	}
+
+	bk := buckets[best]
+	return fmt.Sprintf("#%02x%02x%02x", bk.r/bk.n, bk.g/bk.n, bk.b/bk.n), nil
+}
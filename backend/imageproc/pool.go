@@ -0,0 +1,33 @@
+package imageproc
+
+import (
+	"context"
+
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+// Pool bounds how many images are processed at once, so a burst of large
+// uploads queues up instead of starving every other request of CPU.
+type Pool struct {
+	slots chan struct{}
+}
+
+func NewPool(workers int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Pool{slots: make(chan struct{}, workers)}
+}
+
+// Process waits for a free slot, then processes data like the package level
+// Process. It gives up when ctx is done before a slot frees up.
+func (p *Pool) Process(ctx context.Context, data []byte) (map[domain.ImageSize][]byte, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-p.slots }()
+
+	return Process(data)
+}
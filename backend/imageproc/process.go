@@ -0,0 +1,131 @@
+// Package imageproc turns uploaded images into the re-encoded variants served
+// to clients. Decoding and re-encoding drops every metadata block (EXIF, GPS,
+// comments), after the EXIF orientation has been applied to the pixels.
+package imageproc
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
+)
+
+// maxDimensions bounds the longest side of each variant. Images are never upscaled.
+var maxDimensions = map[domain.ImageSize]int{
+	domain.ImageSizeThumb:  240,
+	domain.ImageSizeMedium: 720,
+	domain.ImageSizeFull:   1600,
+}
+
+const jpegQuality = 85
+
+// Process decodes data and returns every variant, encoded as JPEG for JPEG
+// uploads and as PNG otherwise so transparency survives. data is expected to
+// have passed Validate; image data that fails to decode is reported as a
+// corrupt image.
+func Process(data []byte) (map[domain.ImageSize][]byte, error) {
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, &ValidationError{Reason: ReasonCorruptImage, Message: "image data is corrupt"}
+	}
+	if format == "jpeg" {
+		src = applyOrientation(toNRGBA(src), jpegOrientation(data))
+	}
+
+	variants := make(map[domain.ImageSize][]byte, len(maxDimensions))
+	for size, max := range maxDimensions {
+		var buf bytes.Buffer
+		img := fit(src, max)
+		if format == "jpeg" {
+			err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+		} else {
+			err = png.Encode(&buf, img)
+		}
+		if err != nil {
+			return nil, err
+		}
+		variants[size] = buf.Bytes()
+	}
+	return variants, nil
+}
+
+func toNRGBA(src image.Image) *image.NRGBA {
+	if img, ok := src.(*image.NRGBA); ok && img.Rect.Min == (image.Point{}) {
+		return img
+	}
+	b := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+	return dst
+}
+
+// fit scales src down so that neither side exceeds max.
+func fit(src image.Image, max int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= max && h <= max {
+		return src
+	}
+	if w >= h {
+		h = h * max / w
+		w = max
+	} else {
+		w = w * max / h
+		h = max
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	return resize(toNRGBA(src), w, h)
+}
+
+// resize downscales with a box filter: every destination pixel is the
+// average of the source pixels it covers.
+func resize(src *image.NRGBA, w int, h int) *image.NRGBA {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, (y+1)*sh/h
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, (x+1)*sw/w
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					// weight colors by alpha so transparent pixels don't darken edges
+					pa := uint64(p[3])
+					r += uint64(p[0]) * pa
+					g += uint64(p[1]) * pa
+					b += uint64(p[2]) * pa
+					a += pa
+					n++
+				}
+			}
+
+			d := dst.Pix[y*dst.Stride+x*4:]
+			if a > 0 {
+				d[0] = uint8(r / a)
+				d[1] = uint8(g / a)
+				d[2] = uint8(b / a)
+			}
+			d[3] = uint8(a / n)
+		}
+	}
+	return dst
+}
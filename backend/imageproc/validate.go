@@ -0,0 +1,113 @@
+package imageproc
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"os"
+	"strconv"
+)
+
+// Reasons reported to clients when an upload is rejected.
+const (
+	ReasonEmptyFile          = "empty_file"
+	ReasonFileTooLarge       = "file_too_large"
+	ReasonUnsupportedFormat  = "unsupported_format"
+	ReasonCorruptImage       = "corrupt_image"
+	ReasonDimensionsTooLarge = "dimensions_too_large"
+	ReasonTooManyPixels      = "too_many_pixels"
+)
+
+const (
+	defaultMaxBytes     = 5 << 20
+	defaultMaxDimension = 8000
+	defaultMaxPixels    = 25000000
+)
+
+// ValidationError explains why an upload was rejected. Reason is one of the
+// Reason constants and is meant for machines, Message for humans.
+type ValidationError struct {
+	Reason  string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Reason + ": " + e.Message
+}
+
+// Limits bounds what an upload may look like. They are configurable per
+// deployment through IMAGE_MAX_BYTES, IMAGE_MAX_WIDTH, IMAGE_MAX_HEIGHT
+// and IMAGE_MAX_PIXELS.
+type Limits struct {
+	MaxBytes  int64
+	MaxWidth  int
+	MaxHeight int
+	// MaxPixels caps width*height, which is what decoding allocates, so small
+	// files claiming huge dimensions (decompression bombs) are refused early.
+	MaxPixels int
+}
+
+func LimitsFromEnv() Limits {
+	return Limits{
+		MaxBytes:  int64(envInt("IMAGE_MAX_BYTES", defaultMaxBytes)),
+		MaxWidth:  envInt("IMAGE_MAX_WIDTH", defaultMaxDimension),
+		MaxHeight: envInt("IMAGE_MAX_HEIGHT", defaultMaxDimension),
+		MaxPixels: envInt("IMAGE_MAX_PIXELS", defaultMaxPixels),
+	}
+}
+
+// Validate runs every check that doesn't need the pixels: size, magic bytes
+// and the dimensions declared in the header. Process performs the full decode
+// and reports corrupt image data.
+func Validate(data []byte, limits Limits) error {
+	if len(data) == 0 {
+		return &ValidationError{Reason: ReasonEmptyFile, Message: "image must not be empty"}
+	}
+	if int64(len(data)) > limits.MaxBytes {
+		return &ValidationError{Reason: ReasonFileTooLarge, Message: fmt.Sprintf("image must be at most %d bytes", limits.MaxBytes)}
+	}
+
+	format := sniffFormat(data)
+	if format == "" {
+		return &ValidationError{Reason: ReasonUnsupportedFormat, Message: "image must be a JPEG, PNG or GIF"}
+	}
+
+	config, decoded, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || decoded != format {
+		return &ValidationError{Reason: ReasonCorruptImage, Message: "image header is corrupt"}
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return &ValidationError{Reason: ReasonCorruptImage, Message: "image has no pixels"}
+	}
+	if config.Width > limits.MaxWidth || config.Height > limits.MaxHeight {
+		return &ValidationError{Reason: ReasonDimensionsTooLarge, Message: fmt.Sprintf("image must be at most %dx%d pixels", limits.MaxWidth, limits.MaxHeight)}
+	}
+	if config.Width*config.Height > limits.MaxPixels {
+		return &ValidationError{Reason: ReasonTooManyPixels, Message: fmt.Sprintf("image must have at most %d pixels", limits.MaxPixels)}
+	}
+
+	return nil
+}
+
+// sniffFormat recognizes the supported formats by their magic bytes and
+// returns their image package name, or "" for anything else.
+func sniffFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return "jpeg"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return "gif"
+	default:
+		return ""
+	}
+}
+
+func envInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,195 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+var testLimits = Limits{
+	MaxBytes:  1 << 20,
+	MaxWidth:  8000,
+	MaxHeight: 8000,
+	MaxPixels: 25000000,
+}
+
+func testImage(w int, h int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x * 8), G: uint8(y * 8), B: 0x80, A: 0xFF})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeGIF(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withPNGSize rewrites the dimensions in the IHDR chunk of a PNG, fixing up
+// its CRC, so the header claims a size the pixel data doesn't have.
+func withPNGSize(t *testing.T, data []byte, w uint32, h uint32) []byte {
+	t.Helper()
+	// 8 bytes signature, 4 bytes length, then "IHDR", width and height
+	const ihdr = 8 + 4
+	if string(data[ihdr:ihdr+4]) != "IHDR" {
+		t.Fatal("first chunk is not IHDR")
+	}
+	out := append([]byte(nil), data...)
+	binary.BigEndian.PutUint32(out[ihdr+4:], w)
+	binary.BigEndian.PutUint32(out[ihdr+8:], h)
+	crc := crc32.ChecksumIEEE(out[ihdr : ihdr+4+13])
+	binary.BigEndian.PutUint32(out[ihdr+4+13:], crc)
+	return out
+}
+
+// withGIFSize rewrites the logical screen size of a GIF.
+func withGIFSize(data []byte, w uint16, h uint16) []byte {
+	out := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint16(out[6:], w)
+	binary.LittleEndian.PutUint16(out[8:], h)
+	return out
+}
+
+func reasonOf(err error) string {
+	var invalid *ValidationError
+	if errors.As(err, &invalid) {
+		return invalid.Reason
+	}
+	return ""
+}
+
+func TestValidateAcceptsSupportedFormats(t *testing.T) {
+	img := testImage(32, 24)
+	for name, data := range map[string][]byte{
+		"png":  encodePNG(t, img),
+		"jpeg": encodeJPEG(t, img),
+		"gif":  encodeGIF(t, img),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := Validate(data, testLimits); err != nil {
+				t.Errorf("Validate: %v", err)
+			}
+			if _, err := Process(data); err != nil {
+				t.Errorf("Process: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsMalformedFiles(t *testing.T) {
+	img := testImage(32, 24)
+	pngData := encodePNG(t, img)
+	jpegData := encodeJPEG(t, img)
+	gifData := encodeGIF(t, img)
+
+	tests := []struct {
+		name   string
+		data   []byte
+		limits Limits
+		reason string
+	}{
+		{"zero bytes", nil, testLimits, ReasonEmptyFile},
+		{"too many bytes", pngData, Limits{MaxBytes: 64, MaxWidth: 8000, MaxHeight: 8000, MaxPixels: 25000000}, ReasonFileTooLarge},
+
+		{"plain text", []byte("definitely not an image"), testLimits, ReasonUnsupportedFormat},
+		{"pdf", []byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n"), testLimits, ReasonUnsupportedFormat},
+		{"bmp", append([]byte("BM"), make([]byte, 64)...), testLimits, ReasonUnsupportedFormat},
+		{"webp", []byte("RIFF\x24\x00\x00\x00WEBPVP8 "), testLimits, ReasonUnsupportedFormat},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="1" height="1"/>`), testLimits, ReasonUnsupportedFormat},
+		{"png magic on gif data", append([]byte("\x89PNG\r\n\x1a\n"), gifData[6:]...), testLimits, ReasonCorruptImage},
+		{"gif magic on png data", append([]byte("GIF89a"), pngData[8:]...), testLimits, ReasonCorruptImage},
+		{"jpeg magic on png data", append([]byte{0xFF, 0xD8, 0xFF}, pngData...), testLimits, ReasonCorruptImage},
+
+		{"png truncated in the header", pngData[:20], testLimits, ReasonCorruptImage},
+		{"jpeg truncated in the header", jpegData[:10], testLimits, ReasonCorruptImage},
+		{"gif truncated in the header", gifData[:8], testLimits, ReasonCorruptImage},
+		{"magic bytes only", []byte("\x89PNG\r\n\x1a\n"), testLimits, ReasonCorruptImage},
+		{"zero width", withPNGSize(t, pngData, 0, 24), testLimits, ReasonCorruptImage},
+
+		{"width over the limit", withPNGSize(t, pngData, 100000, 1), testLimits, ReasonDimensionsTooLarge},
+		{"height over the limit", withPNGSize(t, pngData, 1, 100000), testLimits, ReasonDimensionsTooLarge},
+		{"gif screen over the limit", withGIFSize(gifData, 65535, 65535), testLimits, ReasonDimensionsTooLarge},
+		// A few hundred bytes claiming 64 million pixels: decoding would
+		// allocate 256 MB, so it must be refused from the header alone.
+		{"decompression bomb", withPNGSize(t, pngData, 8000, 8000), testLimits, ReasonTooManyPixels},
+		{"pixels over a lower limit", pngData, Limits{MaxBytes: 1 << 20, MaxWidth: 8000, MaxHeight: 8000, MaxPixels: 100}, ReasonTooManyPixels},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.data, tt.limits)
+			if got := reasonOf(err); got != tt.reason {
+				t.Errorf("Validate: reason = %q (err %v), want %q", got, err, tt.reason)
+			}
+		})
+	}
+}
+
+// Files whose header is intact but whose pixel data is broken pass Validate
+// and must be caught by the full decode in Process.
+func TestProcessRejectsCorruptPixelData(t *testing.T) {
+	// Large enough for the pixel data to outweigh the headers
+	img := testImage(256, 256)
+	pngData := encodePNG(t, img)
+	jpegData := encodeJPEG(t, img)
+	gifData := encodeGIF(t, img)
+
+	garbled := append([]byte(nil), pngData...)
+	for i := 40; i < len(garbled)-12; i++ {
+		garbled[i] ^= 0x5A
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"png truncated in the pixel data", pngData[:len(pngData)/2]},
+		{"jpeg truncated in the pixel data", jpegData[:len(jpegData)/2]},
+		{"gif truncated in the pixel data", gifData[:len(gifData)-8]},
+		{"png with garbled pixel data", garbled},
+		{"png larger than its pixel data", withPNGSize(t, pngData, 512, 512)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Validate(tt.data, testLimits); err != nil {
+				t.Fatalf("Validate: %v, want the header to pass", err)
+			}
+			_, err := Process(tt.data)
+			if got := reasonOf(err); got != ReasonCorruptImage {
+				t.Errorf("Process: reason = %q (err %v), want %q", got, err, ReasonCorruptImage)
+			}
+		})
+	}
+}
@@ -0,0 +1,411 @@
+// Package jwtkey manages the asymmetric keys access tokens are signed with.
+// Every key has an ID sent as the token's kid header. The newest key signs,
+// and once a newer key replaces it a key still verifies for a grace period,
+// so tokens issued just before a rotation stay valid until they expire.
+//
+// Keys the manager generates are named <alg>-<unix nanoseconds>, which
+// records when they were created. Only those are rotated and deleted once
+// retired; keys put in the directory by hand are left to whoever put them
+// there.
+package jwtkey
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+)
+
+const (
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+)
+
+const rsaKeyBits = 2048
+
+var (
+	ErrUnknownKey = errors.New("unknown signing key")
+	// ErrReadOnlyDir is returned once when a new key can't be written, after
+	// which the current key keeps signing.
+	ErrReadOnlyDir = errors.New("JWT key directory is read-only, rotation is disabled")
+)
+
+// Key is a signing key. CreatedAt orders keys: the newest one signs.
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	CreatedAt time.Time
+	signer    crypto.Signer
+	// generated keys were created by a Manager, which may rotate and delete them.
+	generated bool
+}
+
+// Config configures a Manager.
+type Config struct {
+	// Dir holds the keys as PKCS#8 PEM files named <kid>.pem. Keys created
+	// by rotation are written there too. Without a Dir, or when it can't be
+	// written, generated keys only live in memory and access tokens don't
+	// survive a restart.
+	Dir string
+	// Algorithm is used for new keys, AlgEdDSA or AlgRS256.
+	Algorithm string
+	// Rotation is how old the newest key may get before a new one replaces
+	// it. Zero disables rotation, and so does a newest key that was not
+	// generated.
+	Rotation time.Duration
+	// Grace is how long a replaced key keeps verifying tokens. It must be at
+	// least the lifetime of an access token.
+	Grace time.Duration
+	// Production refuses to start without keys in Dir.
+	Production bool
+}
+
+// ConfigFromEnv reads JWT_KEY_DIR, JWT_KEY_ALG (default EdDSA),
+// JWT_KEY_ROTATION (default 720h), JWT_KEY_GRACE (default 1h) and ENV.
+func ConfigFromEnv() Config {
+	return Config{
+		Dir:        os.Getenv("JWT_KEY_DIR"),
+		Algorithm:  getEnv("JWT_KEY_ALG", AlgEdDSA),
+		Rotation:   getEnvDuration("JWT_KEY_ROTATION", 30*24*time.Hour),
+		Grace:      getEnvDuration("JWT_KEY_GRACE", time.Hour),
+		Production: os.Getenv("ENV") == "production",
+	}
+}
+
+type Manager struct {
+	config Config
+
+	mu   sync.RWMutex
+	keys []*Key // oldest first
+	// readOnly is set once writing to Dir failed for lack of permission.
+	readOnly bool
+}
+
+// NewManager loads the keys of config.Dir. Outside production a key is
+// generated when there are none.
+func NewManager(config Config) (*Manager, error) {
+	if config.Algorithm != AlgEdDSA && config.Algorithm != AlgRS256 {
+		return nil, fmt.Errorf("unsupported JWT key algorithm %q", config.Algorithm)
+	}
+
+	m := &Manager{config: config}
+	if config.Dir != "" {
+		keys, err := loadKeys(config.Dir)
+		if err != nil {
+			return nil, err
+		}
+		m.keys = keys
+	}
+
+	if len(m.keys) == 0 {
+		if config.Production {
+			return nil, errors.New("no JWT signing keys configured, set JWT_KEY_DIR to a directory of PEM keys")
+		}
+		if _, err := m.Rotate(); errors.Is(err, ErrReadOnlyDir) {
+			// Only development gets here, where a key lost on restart
+			// merely makes clients refresh their access tokens
+			key, err := generateKey(config.Algorithm)
+			if err != nil {
+				return nil, err
+			}
+			m.keys = append(m.keys, key)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	// Rotations missed while the server was down are caught up by Run
+	m.forgetRetired(time.Now())
+	return m, nil
+}
+
+// NewManagerFromEnv builds a Manager from ConfigFromEnv.
+func NewManagerFromEnv() (*Manager, error) {
+	return NewManager(ConfigFromEnv())
+}
+
+// Sign signs claims with the newest key.
+func (m *Manager) Sign(claims jwt.Claims) (string, error) {
+	m.mu.RLock()
+	key := m.keys[len(m.keys)-1]
+	m.mu.RUnlock()
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signer)
+}
+
+// Keyfunc returns the public key named by the token's kid, for jwt.Parse.
+// Keys past their grace period are no longer known.
+func (m *Manager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, key := range m.keys {
+		if key.ID != kid {
+			continue
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("key %s does not sign with %s", kid, token.Method.Alg())
+		}
+		return key.signer.Public(), nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// Methods returns the algorithms tokens may be signed with.
+func (m *Manager) Methods() []string {
+	return []string{AlgEdDSA, AlgRS256}
+}
+
+// Keys returns the keys that currently verify tokens, oldest first.
+func (m *Manager) Keys() []*Key {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]*Key(nil), m.keys...)
+}
+
+// Rotate makes a new key the signing key. The previous keys keep verifying
+// until their grace period ends. If Dir can't be written, it fails with
+// ErrReadOnlyDir and the current key keeps signing.
+func (m *Manager) Rotate() (*Key, error) {
+	key, err := generateKey(m.config.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if m.config.Dir != "" {
+		if err := writeKey(m.config.Dir, key); err != nil {
+			if isReadOnly(err) {
+				m.mu.Lock()
+				m.readOnly = true
+				m.mu.Unlock()
+				return nil, errors.Wrap(ErrReadOnlyDir, err.Error())
+			}
+			return nil, err
+		}
+	}
+
+	m.mu.Lock()
+	m.keys = append(m.keys, key)
+	m.mu.Unlock()
+	return key, nil
+}
+
+// RotateIfDue rotates when the signing key is a generated one older than the
+// rotation period, and forgets the keys whose grace period has ended,
+// deleting the files of generated ones.
+func (m *Manager) RotateIfDue(now time.Time) error {
+	m.mu.RLock()
+	newest := m.keys[len(m.keys)-1]
+	readOnly := m.readOnly
+	m.mu.RUnlock()
+
+	if m.config.Rotation > 0 && newest.generated && !readOnly && now.Sub(newest.CreatedAt) >= m.config.Rotation {
+		if _, err := m.Rotate(); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range m.forgetRetired(now) {
+		if !key.generated || m.config.Dir == "" {
+			continue
+		}
+		err := os.Remove(filepath.Join(m.config.Dir, key.ID+".pem"))
+		if err != nil && !os.IsNotExist(err) && !isReadOnly(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// forgetRetired drops the keys whose grace period has ended and returns them.
+func (m *Manager) forgetRetired(now time.Time) []*Key {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var retired []*Key
+	for len(m.keys) > 1 && now.Sub(m.keys[1].CreatedAt) >= m.config.Grace {
+		retired = append(retired, m.keys[0])
+		m.keys = m.keys[1:]
+	}
+	return retired
+}
+
+// Run calls RotateIfDue right away and then every interval until ctx is
+// done, reporting failures to onError.
+func (m *Manager) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	if err := m.RotateIfDue(time.Now()); err != nil {
+		onError(err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := m.RotateIfDue(now); err != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+func generateKey(alg string) (*Key, error) {
+	var signer crypto.Signer
+	var err error
+	switch alg {
+	case AlgEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	case AlgRS256:
+		signer, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	default:
+		return nil, fmt.Errorf("unsupported JWT key algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return newKey(fmt.Sprintf("%s-%d", strings.ToLower(alg), now.UnixNano()), signer, now, true)
+}
+
+// generatedKeyTime returns the creation time recorded in the ID of a
+// generated key, and false for any other ID.
+func generatedKeyTime(id string) (time.Time, bool) {
+	alg, nanos, ok := strings.Cut(id, "-")
+	if !ok || (alg != strings.ToLower(AlgEdDSA) && alg != strings.ToLower(AlgRS256)) {
+		return time.Time{}, false
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil || n <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, n), true
+}
+
+func newKey(id string, signer crypto.Signer, createdAt time.Time, generated bool) (*Key, error) {
+	key := &Key{ID: id, CreatedAt: createdAt, signer: signer, generated: generated}
+	switch signer.(type) {
+	case ed25519.PrivateKey:
+		key.Method = jwt.SigningMethodEdDSA
+	case *rsa.PrivateKey:
+		key.Method = jwt.SigningMethodRS256
+	default:
+		return nil, fmt.Errorf("key %s: unsupported key type %T", id, signer)
+	}
+	return key, nil
+}
+
+// loadKeys reads every .pem file of dir. A key's ID is its file name without
+// the extension. Generated keys take their creation time from the ID, as
+// copying or restoring the files changes their modification time, which
+// only orders the keys put there by hand.
+func loadKeys(dir string) ([]*Key, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*Key
+	for _, path := range paths {
+		key, err := readKey(path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+func readKey(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, path)
+	}
+
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported key type %T", path, parsed)
+	}
+	id := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if createdAt, ok := generatedKeyTime(id); ok {
+		return newKey(id, signer, createdAt, true)
+	}
+	return newKey(id, signer, info.ModTime(), false)
+}
+
+func writeKey(dir string, key *Key) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.signer)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	return os.WriteFile(filepath.Join(dir, key.ID+".pem"), data, 0600)
+}
+
+// isReadOnly reports whether err comes from writing to a directory the
+// server may not change, such as a mounted secret.
+func isReadOnly(err error) bool {
+	return errors.Is(err, fs.ErrPermission) || errors.Is(err, syscall.EROFS)
+}
+
+func getEnv(key string, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvDuration reads a duration such as "720h". An explicit "0" is kept.
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value < 0 {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,147 @@
+package jwtkey
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// writeTestKey writes an Ed25519 key to dir as if it was generated at createdAt.
+func writeTestKey(t *testing.T, dir string, id string, createdAt time.Time) *Key {
+	t.Helper()
+	_, signer, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := newKey(id, signer, createdAt, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeKey(dir, key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func generatedID(createdAt time.Time) string {
+	return fmt.Sprintf("eddsa-%d", createdAt.UnixNano())
+}
+
+func pemFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, path := range paths {
+		paths[i] = filepath.Base(path)
+	}
+	return paths
+}
+
+func TestSignedTokensVerify(t *testing.T) {
+	m, err := NewManager(Config{Algorithm: AlgEdDSA, Grace: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := m.Sign(jwt.MapClaims{"user_id": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwt.Parse(signed, m.Keyfunc, jwt.WithValidMethods(m.Methods())); err != nil {
+		t.Errorf("Parse: %v", err)
+	}
+}
+
+// Copying or restoring a key directory resets modification times, which
+// must not change which generated key signs.
+func TestGeneratedKeysOrderedByID(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	older := writeTestKey(t, dir, generatedID(now.Add(-2*time.Hour)), now.Add(-2*time.Hour))
+	newer := writeTestKey(t, dir, generatedID(now.Add(-time.Minute)), now.Add(-time.Minute))
+	if err := os.Chtimes(filepath.Join(dir, older.ID+".pem"), now, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, newer.ID+".pem"), now.Add(-time.Hour), now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewManager(Config{Dir: dir, Algorithm: AlgEdDSA, Grace: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := m.Keys()
+	if len(keys) != 2 || keys[1].ID != newer.ID {
+		t.Errorf("signing key = %s, want %s", keys[len(keys)-1].ID, newer.ID)
+	}
+}
+
+func TestRotateIfDue(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	old := writeTestKey(t, dir, generatedID(now.Add(-48*time.Hour)), now.Add(-48*time.Hour))
+
+	m, err := NewManager(Config{Dir: dir, Algorithm: AlgEdDSA, Rotation: 24 * time.Hour, Grace: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RotateIfDue(now); err != nil {
+		t.Fatal(err)
+	}
+	keys := m.Keys()
+	if len(keys) != 2 || keys[0].ID != old.ID {
+		t.Fatalf("keys after rotation = %v, want the old key and a new one", keys)
+	}
+	if files := pemFiles(t, dir); len(files) != 2 {
+		t.Errorf("files after rotation = %v, want the new key written", files)
+	}
+
+	// Once the grace period ends the old key is forgotten and deleted
+	if err := m.RotateIfDue(now.Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	keys = m.Keys()
+	if len(keys) != 1 || keys[0].ID == old.ID {
+		t.Errorf("keys after the grace period = %v, want only the new key", keys)
+	}
+	if files := pemFiles(t, dir); len(files) != 1 || files[0] != keys[0].ID+".pem" {
+		t.Errorf("files after the grace period = %v, want only %s.pem", files, keys[0].ID)
+	}
+}
+
+func TestProvidedKeysAreNotRotated(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	provided := writeTestKey(t, dir, "main", now)
+	if err := os.Chtimes(filepath.Join(dir, "main.pem"), now.Add(-48*time.Hour), now.Add(-48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewManager(Config{Dir: dir, Algorithm: AlgEdDSA, Rotation: 24 * time.Hour, Grace: time.Hour, Production: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RotateIfDue(now.Add(365 * 24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if keys := m.Keys(); len(keys) != 1 || keys[0].ID != provided.ID {
+		t.Errorf("keys = %v, want only %s", keys, provided.ID)
+	}
+	if files := pemFiles(t, dir); len(files) != 1 {
+		t.Errorf("files = %v, want only main.pem", files)
+	}
+}
+
+func TestProductionRequiresKeys(t *testing.T) {
+	if _, err := NewManager(Config{Dir: t.TempDir(), Algorithm: AlgEdDSA, Production: true}); err == nil {
+		t.Error("NewManager without keys in production: want an error")
+	}
+}
@@ -0,0 +1,59 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogSender prints messages instead of sending them, for development.
+type LogSender struct {
+	mu   sync.Mutex
+	w    io.Writer
+	from string
+}
+
+func NewLogSender(w io.Writer, from string) *LogSender {
+	return &LogSender{w: w, from: from}
+}
+
+func (s *LogSender) Send(ctx context.Context, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := fmt.Fprintf(s.w, "---- mail ----\n%s---- end of mail ----\n", strings.ReplaceAll(string(format(s.from, msg)), "\r\n", "\n"))
+	return err
+}
+
+// FileSender writes every message to its own .eml file in a directory, for
+// development and for inspecting mail in tests.
+type FileSender struct {
+	dir  string
+	from string
+}
+
+func NewFileSender(dir string, from string) (*FileSender, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileSender{dir: dir, from: from}, nil
+}
+
+func (s *FileSender) Send(ctx context.Context, msg Message) error {
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), sanitize(msg.To))
+	return os.WriteFile(filepath.Join(s.dir, name), format(s.from, msg), 0644)
+}
+
+// sanitize keeps an address usable in a file name.
+func sanitize(address string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, address)
+}
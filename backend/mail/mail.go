@@ -0,0 +1,60 @@
+// Package mail sends email such as password reset links. The sender is
+// picked with MAIL_SENDER: "log" (default) prints messages, "file" writes
+// them to MAIL_DIR, and "smtp" delivers them through SMTP_ADDR.
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewSenderFromEnv builds the sender selected by MAIL_SENDER. With
+// ENV=production the log sender is refused, as it would print reset tokens
+// to the server log.
+func NewSenderFromEnv() (Sender, error) {
+	from := getEnv("MAIL_FROM", "noreply@mercari.local")
+	switch kind := getEnv("MAIL_SENDER", "log"); kind {
+	case "log":
+		if os.Getenv("ENV") == "production" {
+			return nil, errors.New("MAIL_SENDER=log is not allowed in production, set it to smtp or file")
+		}
+		return NewLogSender(os.Stdout, from), nil
+	case "file":
+		return NewFileSender(getEnv("MAIL_DIR", "mail-out"), from)
+	case "smtp":
+		return NewSMTPSender(SMTPConfig{
+			Addr:     os.Getenv("SMTP_ADDR"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		})
+	default:
+		return nil, fmt.Errorf("unknown MAIL_SENDER %q", kind)
+	}
+}
+
+// format renders msg as an RFC 5322 message.
+func format(from string, msg Message) []byte {
+	return []byte(fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n", from, msg.To, msg.Subject, msg.Body))
+}
+
+func getEnv(key string, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,44 @@
+package mail
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+
+	"github.com/pkg/errors"
+)
+
+type SMTPConfig struct {
+	// Addr is the host:port of the server.
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPSender delivers messages through an SMTP server, authenticating with
+// PLAIN when a username is configured.
+type SMTPSender struct {
+	config SMTPConfig
+	auth   smtp.Auth
+}
+
+func NewSMTPSender(config SMTPConfig) (*SMTPSender, error) {
+	if config.Addr == "" {
+		return nil, errors.New("SMTP_ADDR is required")
+	}
+	host, _, err := net.SplitHostPort(config.Addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid SMTP_ADDR")
+	}
+
+	s := &SMTPSender{config: config}
+	if config.Username != "" {
+		s.auth = smtp.PlainAuth("", config.Username, config.Password, host)
+	}
+	return s, nil
+}
+
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	return smtp.SendMail(s.config.Addr, s.auth, s.config.From, []string{msg.To}, format(s.config.From, msg))
+}
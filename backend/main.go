@@ -7,14 +7,22 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"runtime"
+	"strconv"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	echojwt "github.com/labstack/echo-jwt/v4"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/blob"
 	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/db"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/domain"
 	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/handler"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/imageproc"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/jwtkey"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/mail"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/oidc"
+	"github.com/xu-jiach/mecari-build-hackathon-2023/backend/password"
 )
 
 const (
@@ -28,6 +36,9 @@ func main() {
 
 func run(ctx context.Context) int {
 	e := echo.New()
+	// Login throttling goes by c.RealIP(), which must not trust headers
+	// clients can set such as X-Forwarded-For
+	e.IPExtractor = echo.ExtractIPDirect()
 
 	// Middleware
 	e.Use(middleware.Recover())
@@ -49,18 +60,10 @@ func run(ctx context.Context) int {
 	}
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins: []string{frontURL},
-		AllowMethods: []string{"GET", "PUT", "DELETE", "OPTIONS", "POST"},
+		AllowMethods: []string{"GET", "PUT", "PATCH", "DELETE", "OPTIONS", "POST"},
 	}))
 	e.Use(middleware.BodyLimit("5M"))
 
-	// jwt
-	config := echojwt.Config{
-		NewClaimsFunc: func(c echo.Context) jwt.Claims {
-			return new(handler.JwtCustomClaims)
-		},
-		SigningKey: []byte(handler.GetSecret()),
-	}
-
 	// db
 	sqlDB, err := db.PrepareDB(ctx)
 	if err != nil {
@@ -69,42 +72,167 @@ func run(ctx context.Context) int {
 	}
 	defer sqlDB.Close()
 
+	blobStore, err := blob.NewStoreFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to prepare blob store: %s\n", err)
+		return exitError
+	}
+
+	mailer, err := mail.NewSenderFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to prepare mail sender: %s\n", err)
+		return exitError
+	}
+
+	passwordPolicy, err := password.PolicyFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load password policy: %s\n", err)
+		return exitError
+	}
+
+	keys, err := jwtkey.NewManagerFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to prepare JWT signing keys: %s\n", err)
+		return exitError
+	}
+	rotateCtx, stopRotation := context.WithCancel(ctx)
+	defer stopRotation()
+	go keys.Run(rotateCtx, time.Minute, func(err error) {
+		e.Logger.Errorf("failed to rotate JWT signing keys: %s", err)
+	})
+
 	h := handler.Handler{
 		DB:                 sqlDB,
 		UserRepo:           db.NewUserRepository(sqlDB),
-		ItemRepo:           db.NewItemRepository(sqlDB),
+		ItemRepo:           db.NewItemRepository(sqlDB, blobStore),
 		OnsitePurchaseRepo: db.NewOnsitePurchaseRepository(sqlDB),
+		PriceWatchRepo:     db.NewPriceWatchRepository(sqlDB),
+		NotificationRepo:   db.NewNotificationRepository(sqlDB),
+		PurchaseRepo:       db.NewPurchaseRepository(sqlDB),
+		DuplicateRepo:      db.NewDuplicateRepository(sqlDB),
+		AttributeRepo:      db.NewAttributeRepository(sqlDB),
+		SessionRepo:        db.NewSessionRepository(sqlDB),
+		LoginAttemptRepo:   db.NewLoginAttemptRepository(sqlDB),
+		PasswordResetRepo:  db.NewPasswordResetRepository(sqlDB),
+		TwoFactorRepo:      db.NewTwoFactorRepository(sqlDB),
+		IdentityRepo:       db.NewIdentityRepository(sqlDB),
+		APIKeyRepo:         db.NewAPIKeyRepository(sqlDB),
+		ReviewRepo:         db.NewReviewRepository(sqlDB),
+		FollowRepo:         db.NewFollowRepository(sqlDB),
+		BlobStore:          blobStore,
+		ImagePool:          imageproc.NewPool(imageWorkers()),
+		ImageLimits:        imageproc.LimitsFromEnv(),
+		Keys:               keys,
+		PasswordPolicy:     passwordPolicy,
+		Mailer:             mailer,
+	}
+	if config := oidc.ConfigFromEnv(); config.Enabled() {
+		h.OIDC = oidc.NewProvider(config, nil)
+	}
+
+	// jwt
+	config := echojwt.Config{
+		ParseTokenFunc: h.ParseToken,
 	}
 
 	// Routes
-	e.POST("/initialize", h.Initialize)
-	e.GET("/log", h.AccessLog)
+	// The reset drops every user, so it is guarded by where it comes from rather than a login
+	e.POST("/initialize", h.Initialize, handler.RequireLocalOrToken(os.Getenv("INITIALIZE_TOKEN")))
 	e.GET("/items", h.GetOnSaleItems)
 	e.GET("/items/:itemID", h.GetItem)
 	e.GET("/items/:itemID/image", h.GetImage)
+	e.GET("/items/:itemID/images/:n", h.GetItemImageAt)
+	e.GET("/items/:itemID/price-history", h.GetPriceHistory)
 	e.GET("/items/categories", h.GetCategories)
+	e.GET("/categories/tree", h.GetCategoryTree)
+	e.GET("/categories/:id/attributes", h.GetCategoryAttributes)
 	e.POST("/register", h.Register)
 	e.POST("/login", h.Login)
+	e.POST("/login/2fa", h.LoginTwoFactor)
+	e.POST("/refresh", h.Refresh)
+	e.POST("/logout", h.Logout)
+	e.GET("/.well-known/jwks.json", h.GetJWKS)
+	e.POST("/oidc/login", h.OIDCLogin)
+	e.POST("/oidc/callback", h.OIDCCallback)
+	e.POST("/password/reset", h.RequestPasswordReset)
+	e.POST("/password/reset/confirm", h.ResetPassword)
+	e.GET("/users/:userID", h.GetProfile)
+	e.GET("/users/:userID/storefront", h.GetStorefront)
+	e.GET("/users/:userID/avatar", h.GetAvatar)
+	e.GET("/users/:userID/reviews", h.GetUserReviews)
 	e.GET("/search", h.SearchItemByKeyword)
 	e.GET("/categories/:id/items", h.GetItemsByCategory) //add the categories display page endpoint
 	e.GET("/search-advanced", h.SearchItemAndInfoByKeyword)
 
 	// Login required
 	l := e.Group("")
-	l.Use(echojwt.WithConfig(config))
+	l.Use(echojwt.WithConfig(config), handler.RestrictAPIKeys)
 	l.GET("/users/:userID/items", h.GetUserItems)
 	l.POST("/items", h.AddItem)
 	l.POST("/items/:itemID/pass", h.GetItemPassword)
 	l.PUT("/items/:itemID", h.EditItem)
+	l.PATCH("/items/:itemID", h.PatchItem)
+	l.POST("/items/:itemID/images", h.AddItemImages)
+	l.DELETE("/items/:itemID/images/:n", h.DeleteItemImage)
+	l.PUT("/items/:itemID/images/order", h.ReorderItemImages)
+	l.GET("/items/:itemID/revisions", h.GetItemRevisions)
+	l.GET("/items/:itemID/revisions/:revisionID/image", h.GetItemRevisionImage)
+	l.POST("/items/:itemID/revisions/:revisionID/revert", h.RevertItem)
+	l.PUT("/items/:itemID/watch", h.WatchItem)
+	l.DELETE("/items/:itemID/watch", h.UnwatchItem)
+	l.GET("/me/notifications", h.GetNotifications)
+	l.POST("/notifications/read", h.ReadAllNotifications)
+	l.POST("/notifications/:notificationID/read", h.ReadNotification)
 	l.POST("/sell", h.Sell)
 	l.POST("/purchase/:itemID", h.Purchase)
 	l.POST("/onsite-purchase/:itemID", h.OnsitePurchase)
 	l.POST("/onsite-purchase/:itemID/available", h.IsOnsitePurchaseAvailable)
+	l.POST("/logout/all", h.LogoutAll)
+	l.GET("/me/sessions", h.GetSessions)
+	l.DELETE("/me/sessions/:sessionID", h.RevokeSession)
+	l.POST("/me/password", h.ChangePassword)
+	l.GET("/me/2fa", h.GetTwoFactor)
+	l.POST("/me/2fa/enroll", h.EnrollTwoFactor)
+	l.POST("/me/2fa/confirm", h.ConfirmTwoFactor)
+	l.POST("/me/2fa/recovery-codes", h.RegenerateRecoveryCodes)
+	l.DELETE("/me/2fa", h.DisableTwoFactor)
+	l.GET("/me/identities", h.GetIdentities)
+	l.POST("/me/identities", h.LinkIdentity)
+	l.POST("/me/identities/callback", h.LinkIdentityCallback)
+	l.DELETE("/me/identities/:identityID", h.UnlinkIdentity)
+	l.PUT("/me/profile", h.UpdateProfile)
+	l.PUT("/me/avatar", h.SetAvatar)
+	l.DELETE("/me/avatar", h.DeleteAvatar)
+	l.GET("/me/purchases", h.GetPurchases)
+	l.POST("/purchases/:purchaseID/reviews", h.AddReview)
+	l.GET("/purchases/:purchaseID/reviews", h.GetPurchaseReviews)
+	l.PUT("/users/:userID/follow", h.Follow)
+	l.DELETE("/users/:userID/follow", h.Unfollow)
+	l.GET("/me/following", h.GetFollowing)
+	l.GET("/me/feed", h.GetFeed)
+	l.GET("/me/api-keys", h.GetAPIKeys)
+	l.POST("/me/api-keys", h.CreateAPIKey)
+	l.DELETE("/me/api-keys/:keyID", h.RevokeAPIKey)
 	l.GET("/balance", h.GetBalance)
 	l.POST("/balance", h.AddBalance)
-	l.POST("/categories", h.AddCategory)
 	l.POST("/generate", h.GenerateDescription)
 
+	// Moderator only
+	m := l.Group("", handler.RequireRole(domain.RoleModerator))
+	m.GET("/admin/duplicates", h.GetDuplicateFlags)
+
+	// Admin only
+	a := l.Group("", handler.RequireRole(domain.RoleAdmin))
+	a.GET("/log", h.AccessLog)
+	a.PUT("/admin/users/:userID/role", h.SetUserRole)
+	a.POST("/categories", h.AddCategory)
+	a.PUT("/categories/:id", h.RenameCategory)
+	a.DELETE("/categories/:id", h.DeleteCategory)
+	a.POST("/categories/:id/merge", h.MergeCategory)
+	a.PUT("/categories/:id/parent", h.SetCategoryParent)
+	a.PUT("/categories/:id/attributes", h.SetCategoryAttribute)
+	a.DELETE("/categories/:id/attributes/:name", h.DeleteCategoryAttribute)
+
 	// Start server
 	go func() {
 		if err := e.Start(":9000"); err != nil && err != http.ErrServerClosed {
@@ -124,6 +252,14 @@ func run(ctx context.Context) int {
 	return exitOK
 }
 
+// imageWorkers is the number of uploads processed concurrently, IMAGE_WORKERS or one per CPU.
+func imageWorkers() int {
+	if n, err := strconv.Atoi(os.Getenv("IMAGE_WORKERS")); err == nil && n > 0 {
+		return n
+	}
+	return runtime.NumCPU()
+}
+
 func logFormat() string {
 	// Customize freely: https://echo.labstack.com/guide/customization/
 	var format string
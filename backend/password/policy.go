@@ -0,0 +1,109 @@
+// Package password decides which passwords users may choose.
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// bcrypt ignores everything after the 72nd byte.
+const maxBytes = 72
+
+// PolicyError explains why a password was rejected.
+type PolicyError struct {
+	Message string
+}
+
+func (e *PolicyError) Error() string {
+	return e.Message
+}
+
+type Policy struct {
+	MinLength int
+	// breached holds the upper case hex SHA-1 of known breached passwords.
+	breached map[string]struct{}
+}
+
+// PolicyFromEnv reads PASSWORD_MIN_LENGTH (default 8) and loads the breached
+// password list named by PASSWORD_BREACHED_LIST, if any.
+func PolicyFromEnv() (Policy, error) {
+	policy := Policy{MinLength: 8}
+	if n, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH")); err == nil && n > 0 {
+		policy.MinLength = n
+	}
+
+	if path := os.Getenv("PASSWORD_BREACHED_LIST"); path != "" {
+		breached, err := LoadBreachedList(path)
+		if err != nil {
+			return Policy{}, err
+		}
+		policy.breached = breached
+	}
+	return policy, nil
+}
+
+// LoadBreachedList reads a file of breached passwords, one per line. Lines
+// may also be SHA-1 hashes in the "HASH:count" format of Have I Been Pwned
+// downloads, so the list doesn't have to hold the passwords themselves.
+func LoadBreachedList(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	breached := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if hash, _, _ := strings.Cut(line, ":"); isSHA1(hash) {
+			breached[strings.ToUpper(hash)] = struct{}{}
+			continue
+		}
+		breached[sha1Hex(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return breached, nil
+}
+
+// Check returns a *PolicyError when password may not be used by the user
+// called name.
+func (p Policy) Check(password string, name string) error {
+	if n := utf8.RuneCountInString(password); n < p.MinLength {
+		return &PolicyError{Message: fmt.Sprintf("password must be at least %d characters", p.MinLength)}
+	}
+	if len(password) > maxBytes {
+		return &PolicyError{Message: fmt.Sprintf("password must be at most %d bytes", maxBytes)}
+	}
+	if name != "" && strings.EqualFold(password, name) {
+		return &PolicyError{Message: "password must not be the same as the name"}
+	}
+	if _, ok := p.breached[sha1Hex(password)]; ok {
+		return &PolicyError{Message: "password appears in a list of breached passwords"}
+	}
+	return nil
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func isSHA1(s string) bool {
+	if len(s) != sha1.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
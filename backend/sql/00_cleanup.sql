@@ -1,4 +1,27 @@
 DROP TABLE items;
 DROP TABLE users;
 DROP TABLE category;
-DROP TABLE status;
\ No newline at end of file
+DROP TABLE status;
+DROP TABLE item_price_history;
+DROP TABLE price_watch;
+DROP TABLE notifications;
+DROP TABLE item_revisions;
+DROP TABLE purchases;
+DROP TABLE item_images;
+DROP TABLE image_variants;
+DROP TABLE image_fingerprints;
+DROP TABLE duplicate_flags;
+DROP TABLE category_attributes;
+DROP TABLE item_attributes;
+DROP TABLE sessions;
+DROP TABLE refresh_tokens;
+DROP TABLE login_attempts;
+DROP TABLE password_resets;
+DROP TABLE totp_secrets;
+DROP TABLE recovery_codes;
+DROP TABLE two_factor_challenges;
+DROP TABLE oidc_states;
+DROP TABLE user_identities;
+DROP TABLE api_keys;
+DROP TABLE reviews;
+DROP TABLE follows;
\ No newline at end of file
@@ -9,21 +9,34 @@ CREATE TABLE IF NOT EXISTS items
     image       blob,
     status      integer,
     created_at  text NOT NULL DEFAULT (DATETIME('now', 'localtime')),
-    updated_at  text NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+    updated_at  text NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    image_hash  varchar(64),
+    listed_at   text
 );
 
 CREATE TABLE IF NOT EXISTS users
 (
-    id       integer primary key autoincrement,
-    name     varchar(50),
-    password binary(60),
-    balance  integer default 0
+    id           integer primary key autoincrement,
+    name         varchar(50),
+    password     binary(60),
+    balance      integer default 0,
+    role         varchar(10) NOT NULL DEFAULT 'user',
+    email        varchar(254),
+    display_name varchar(50),
+    bio          text,
+    location     varchar(100),
+    avatar_hash  varchar(64),
+    created_at   text
 );
 
+CREATE UNIQUE INDEX IF NOT EXISTS users_name ON users (name COLLATE NOCASE);
+
 CREATE TABLE IF NOT EXISTS category
 (
-    id   integer primary key,
-    name varchar(50)
+    id         integer primary key,
+    name       varchar(50),
+    parent_id  integer references category(id),
+    updated_at text
 );
 
 CREATE TABLE IF NOT EXISTS status
@@ -39,4 +52,245 @@ CREATE TABLE IF NOT EXISTS onsite_purchase
     seller_id  integer references users(id),
     buyer_id   integer references users(id),
     password   varchar(255)
-);
\ No newline at end of file
+);
+CREATE TABLE IF NOT EXISTS item_price_history
+(
+    id         integer primary key autoincrement,
+    item_id    integer references items(id),
+    price      integer,
+    changed_by integer references users(id),
+    created_at text NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+);
+
+CREATE TABLE IF NOT EXISTS price_watch
+(
+    user_id    integer references users(id),
+    item_id    integer references items(id),
+    threshold  integer,
+    created_at text NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    primary key (user_id, item_id)
+);
+
+CREATE TABLE IF NOT EXISTS notifications
+(
+    id         integer primary key autoincrement,
+    user_id    integer references users(id),
+    type       integer,
+    item_id    integer references items(id),
+    message    text,
+    is_read    integer default 0,
+    created_at text NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+);
+
+CREATE TABLE IF NOT EXISTS item_revisions
+(
+    id          integer primary key autoincrement,
+    item_id     integer references items(id),
+    editor_id   integer references users(id),
+    name        varchar(50),
+    price       integer,
+    description text,
+    category_id integer,
+    image_hash  varchar(64),
+    changes     text,
+    created_at  text NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+);
+
+CREATE TABLE IF NOT EXISTS purchases
+(
+    id         integer primary key autoincrement,
+    item_id    integer references items(id),
+    buyer_id   integer references users(id),
+    seller_id  integer references users(id),
+    price      integer,
+    created_at text NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+);
+
+CREATE TABLE IF NOT EXISTS item_images
+(
+    item_id  integer references items(id),
+    position   integer,
+    image_hash varchar(64),
+    primary key (item_id, position)
+);
+
+CREATE TABLE IF NOT EXISTS image_variants
+(
+    image_hash   varchar(64),
+    size         varchar(10),
+    variant_hash varchar(64),
+    primary key (image_hash, size)
+);
+
+CREATE TABLE IF NOT EXISTS image_fingerprints
+(
+    image_hash varchar(64) primary key,
+    phash      integer,
+    band0      integer,
+    band1      integer,
+    band2      integer,
+    band3      integer
+);
+
+CREATE TABLE IF NOT EXISTS duplicate_flags
+(
+    id           integer primary key autoincrement,
+    item_id      integer references items(id),
+    duplicate_of integer references items(id),
+    distance     integer,
+    created_at   text NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+);
+
+CREATE TABLE IF NOT EXISTS image_placeholders
+(
+    image_hash varchar(64) primary key,
+    color      varchar(7)
+);
+
+CREATE TABLE IF NOT EXISTS category_attributes
+(
+    id             integer primary key autoincrement,
+    category_id    integer references category(id),
+    name           varchar(50),
+    type           varchar(10),
+    allowed_values text,
+    required       integer default 0,
+    unique (category_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS item_attributes
+(
+    item_id integer references items(id),
+    name    varchar(50),
+    value   text,
+    primary key (item_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS sessions
+(
+    id           integer primary key autoincrement,
+    user_id      integer references users(id),
+    user_agent   text,
+    ip           varchar(45),
+    created_at   text NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    last_seen_at text NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    revoked_at   text
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens
+(
+    id         integer primary key autoincrement,
+    session_id integer references sessions(id),
+    token_hash varchar(64) unique,
+    expires_at text NOT NULL,
+    used_at    text
+);
+
+CREATE TABLE IF NOT EXISTS login_attempts
+(
+    id         integer primary key autoincrement,
+    user_id    integer references users(id),
+    login      varchar(50),
+    ip         varchar(45),
+    success    integer default 0,
+    created_at text NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+);
+
+CREATE TABLE IF NOT EXISTS password_resets
+(
+    id         integer primary key autoincrement,
+    user_id    integer references users(id),
+    ip         varchar(45),
+    token_hash varchar(64) unique,
+    expires_at text NOT NULL,
+    used_at    text,
+    created_at text
+);
+
+CREATE TABLE IF NOT EXISTS totp_secrets
+(
+    user_id      integer primary key references users(id),
+    secret       varchar(32),
+    confirmed_at   text,
+    last_step      integer default 0,
+    failures       integer NOT NULL DEFAULT 0,
+    last_failed_at text
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes
+(
+    id        integer primary key autoincrement,
+    user_id   integer references users(id),
+    code_hash varchar(64),
+    used_at   text
+);
+
+CREATE TABLE IF NOT EXISTS two_factor_challenges
+(
+    id         integer primary key autoincrement,
+    user_id    integer references users(id),
+    token_hash varchar(64) unique,
+    expires_at text NOT NULL,
+    attempts   integer default 0,
+    used_at    text
+);
+
+CREATE TABLE IF NOT EXISTS oidc_states
+(
+    id            integer primary key autoincrement,
+    state_hash    varchar(64) unique,
+    nonce         varchar(64),
+    code_verifier varchar(64),
+    user_id       integer references users(id),
+    expires_at    text NOT NULL,
+    used_at       text
+);
+
+CREATE TABLE IF NOT EXISTS user_identities
+(
+    id         integer primary key autoincrement,
+    user_id    integer references users(id),
+    issuer     varchar(255),
+    subject    varchar(255),
+    email      varchar(254),
+    created_at text NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    UNIQUE (issuer, subject)
+);
+
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id           integer primary key autoincrement,
+    user_id      integer references users(id),
+    name         varchar(50),
+    prefix       varchar(16),
+    key_hash     varchar(64) unique,
+    scopes       text,
+    expires_at   text NOT NULL,
+    last_used_at text,
+    created_at   text NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    revoked_at   text
+);
+
+CREATE TABLE IF NOT EXISTS reviews
+(
+    id          integer primary key autoincrement,
+    purchase_id integer references purchases(id),
+    reviewer_id integer references users(id),
+    reviewee_id integer references users(id),
+    role        varchar(10),
+    rating      integer NOT NULL,
+    comment     text,
+    created_at  text NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    UNIQUE (purchase_id, reviewer_id)
+);
+
+CREATE TABLE IF NOT EXISTS follows
+(
+    follower_id integer references users(id),
+    followee_id integer references users(id),
+    notify      integer NOT NULL DEFAULT 1,
+    created_at  text NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    primary key (follower_id, followee_id)
+);
+
+CREATE INDEX IF NOT EXISTS follows_followee ON follows (followee_id);